@@ -0,0 +1,103 @@
+package reductor
+
+import "math/bits"
+
+// Blocks code values with two alphabets, like DEFLATE. The literal/length
+// alphabet holds all byte values followed by match length buckets. Every
+// length symbol is followed by extra bits and a distance symbol. The
+// distance alphabet starts with repeat symbols, which stand for one of the
+// last numReps distances, like in LZMA and zstd. They are followed by
+// distance buckets, each followed by its own extra bits.
+//
+// A bucket covers a range of values sharing the position of the highest
+// set bit and the mantissa bits below it. Small values have a bucket each.
+const (
+	// lengthBits and distanceBits are the widths of lengths and distances.
+	lengthBits   = 16
+	distanceBits = 30
+	// lengthMantissa and distanceMantissa are the numbers of mantissa bits
+	// of length and distance buckets.
+	lengthMantissa   = 2
+	distanceMantissa = 1
+
+	maxLength   = 1<<lengthBits - 1
+	maxDistance = 1<<distanceBits - 1
+
+	numLengthSyms   = 1<<(lengthMantissa+1) + (lengthBits-lengthMantissa-1)<<lengthMantissa
+	numDistanceSyms = 1<<(distanceMantissa+1) + (distanceBits-distanceMantissa-1)<<distanceMantissa
+	litLenSize      = 256 + numLengthSyms
+	distSize        = numReps + numDistanceSyms
+
+	// Version 1 streams limited lengths to 8 bits and distances to 16 bits.
+	// Their buckets are the same, there are just fewer of them.
+	v1NumLengthSyms   = 1<<(lengthMantissa+1) + (8-lengthMantissa-1)<<lengthMantissa
+	v1NumDistanceSyms = 1<<(distanceMantissa+1) + (16-distanceMantissa-1)<<distanceMantissa
+)
+
+// numReps is the number of recent distances with repeat symbols.
+const numReps = 3
+
+// repHistory holds the most recently used distances, most recent first.
+// It starts afresh with every block and is updated with every pointer, by
+// both the encoder and the decoder.
+type repHistory [numReps]uint32
+
+// newRepHistory returns the history of a block before its first pointer.
+func newRepHistory() repHistory {
+	return repHistory{1, 4, 8}
+}
+
+// find returns the index of distance in h, or -1 if it is not there.
+func (h *repHistory) find(distance uint32) int {
+	for i, d := range h {
+		if d == distance {
+			return i
+		}
+	}
+	return -1
+}
+
+// update moves distance to the front of h, dropping the oldest distance
+// if it was not there yet.
+func (h *repHistory) update(distance uint32) {
+	i := h.find(distance)
+	if i < 0 {
+		i = numReps - 1
+	}
+	copy(h[1:i+1], h[:i])
+	h[0] = distance
+}
+
+// distanceSymbol returns the symbol of distance given the history, along
+// with its extra bits. The caller updates the history afterwards.
+func distanceSymbol(distance uint32, reps *repHistory) (sym int, extra uint32, extraBits uint) {
+	if i := reps.find(distance); i >= 0 {
+		return i, 0, 0
+	}
+	sym, extra, extraBits = bucket(distance, distanceMantissa)
+	return numReps + sym, extra, extraBits
+}
+
+// bucket returns the bucket of v for buckets with k mantissa bits, along
+// with the extra bits selecting v within the bucket.
+func bucket(v uint32, k uint) (sym int, extra uint32, extraBits uint) {
+	if v < 1<<(k+1) {
+		return int(v), 0, 0
+	}
+	n := uint(bits.Len32(v)) - 1
+	m := v >> (n - k) & (1<<k - 1)
+	sym = 1<<(k+1) + int(n-k-1)<<k + int(m)
+	return sym, v & (1<<(n-k) - 1), n - k
+}
+
+// bucketBase returns the smallest value of bucket sym and the number of
+// extra bits following it, for buckets with k mantissa bits.
+func bucketBase(sym int, k uint) (base uint32, extraBits uint) {
+	if sym < 1<<(k+1) {
+		return uint32(sym), 0
+	}
+	i := uint(sym - 1<<(k+1))
+	n := i>>k + k + 1
+	m := uint32(i & (1<<k - 1))
+	return 1<<n | m<<(n-k), n - k
+}
@@ -0,0 +1,77 @@
+package reductor
+
+import (
+	"math/bits"
+	"testing"
+)
+
+func Test_bucket(t *testing.T) {
+	var tests = []struct {
+		name string
+		k    uint
+		max  uint32
+		syms int
+	}{
+		{name: "Lengths", k: lengthMantissa, max: maxLength, syms: numLengthSyms},
+		{name: "Distances", k: distanceMantissa, max: maxDistance, syms: numDistanceSyms},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Check the edges of every bucket, all values would take too long.
+			prev := 0
+			for v := uint32(0); v <= tt.max; v++ {
+				sym, extra, extraBits := bucket(v, tt.k)
+				if sym < prev || sym > prev+1 || sym >= tt.syms {
+					t.Fatalf("unexpected bucket %d for %d", sym, v)
+				}
+				prev = sym
+				if extra >= 1<<extraBits {
+					t.Fatalf("extra bits of %d do not fit in %d bits", v, extraBits)
+				}
+				base, baseBits := bucketBase(sym, tt.k)
+				if base+extra != v || baseBits != extraBits {
+					t.Fatalf("bucket %d does not hold %d (base %d, %d extra bits)", sym, v, base, baseBits)
+				}
+				if extraBits > 1 && extra == 1 {
+					// Skip to the last value of the bucket.
+					v += 1<<extraBits - 3
+				}
+			}
+			if prev != tt.syms-1 {
+				t.Errorf("largest value in bucket %d, want %d", prev, tt.syms-1)
+			}
+		})
+	}
+}
+
+func Test_MinCodeLengthLimit(t *testing.T) {
+	// Codes of the limit must fit every symbol of both alphabets.
+	if want := bits.Len(uint(max(litLenSize, distSize) - 1)); MinCodeLengthLimit != want {
+		t.Errorf("unexpected limit (got %d want %d)", MinCodeLengthLimit, want)
+	}
+}
+
+func Test_repHistory(t *testing.T) {
+	var tests = []struct {
+		name      string
+		distances []uint32
+		want      repHistory
+	}{
+		{name: "Initial", want: repHistory{1, 4, 8}},
+		{name: "New distance", distances: []uint32{100}, want: repHistory{100, 1, 4}},
+		{name: "Most recent distance", distances: []uint32{1}, want: repHistory{1, 4, 8}},
+		{name: "Older distance", distances: []uint32{8}, want: repHistory{8, 1, 4}},
+		{name: "Sequence", distances: []uint32{16, 32, 16, 4, 64}, want: repHistory{64, 4, 16}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newRepHistory()
+			for _, d := range tt.distances {
+				h.update(d)
+			}
+			if h != tt.want {
+				t.Errorf("unexpected history (got %v want %v)", h, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package reductor
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// bitReader reads bits most significant first, matching bitio.Writer.
+// It keeps up to 64 bits buffered, so that codes can be peeked at and
+// resolved through lookup tables instead of being read bit by bit.
+type bitReader struct {
+	r   io.Reader
+	buf []byte
+	pos int
+	end int
+	err error
+
+	// bits holds nbits buffered bits, aligned to the most significant bit.
+	bits  uint64
+	nbits uint
+	// count is the number of bits consumed so far.
+	count int64
+}
+
+func newBitReader(r io.Reader) *bitReader {
+	return &bitReader{r: r, buf: make([]byte, 4096)}
+}
+
+// fill reads more input into buf. It reports whether anything was read.
+func (b *bitReader) fill() bool {
+	if b.err != nil {
+		return false
+	}
+	n, err := b.r.Read(b.buf)
+	for n == 0 && err == nil {
+		n, err = b.r.Read(b.buf)
+	}
+	b.pos, b.end = 0, n
+	b.err = err
+	return n > 0
+}
+
+// refill buffers at least 57 bits, unless input ends sooner.
+func (b *bitReader) refill() {
+	for b.nbits <= 56 {
+		if b.end-b.pos >= 8 {
+			// Take as many whole bytes as fit.
+			v := binary.BigEndian.Uint64(b.buf[b.pos:])
+			k := (64 - b.nbits) / 8
+			low := 64 - b.nbits - 8*k
+			b.bits |= v >> b.nbits >> low << low
+			b.pos += int(k)
+			b.nbits += 8 * k
+			return
+		}
+		if b.pos == b.end && !b.fill() {
+			return
+		}
+		b.bits |= uint64(b.buf[b.pos]) << (56 - b.nbits)
+		b.pos++
+		b.nbits += 8
+	}
+}
+
+// peek returns the next n bits without consuming them, padded with zeros
+// if fewer are buffered. refill must be called first.
+func (b *bitReader) peek(n uint) uint64 {
+	return b.bits >> (64 - n)
+}
+
+func (b *bitReader) consume(n uint) {
+	b.bits <<= n
+	b.nbits -= n
+	b.count += int64(n)
+}
+
+// eofError returns the error to report when input ended too soon.
+func (b *bitReader) eofError() error {
+	if b.err != nil && b.err != io.EOF {
+		return b.err
+	}
+	return io.EOF
+}
+
+// readBits reads n bits, up to 64.
+func (b *bitReader) readBits(n uint) (uint64, error) {
+	if n > 56 {
+		hi, err := b.readBits(n - 32)
+		if err != nil {
+			return 0, err
+		}
+		lo, err := b.readBits(32)
+		return hi<<32 | lo, err
+	}
+	if b.nbits < n {
+		b.refill()
+		if b.nbits < n {
+			return 0, b.eofError()
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	v := b.peek(n)
+	b.consume(n)
+	return v, nil
+}
+
+func (b *bitReader) readBool() (bool, error) {
+	v, err := b.readBits(1)
+	return v == 1, err
+}
+
+// readFull reads len(p) bytes, which do not have to be aligned.
+func (b *bitReader) readFull(p []byte) error {
+	for i := range p {
+		v, err := b.readBits(8)
+		if err != nil {
+			if i > 0 {
+				return noEOF(err)
+			}
+			return err
+		}
+		p[i] = byte(v)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package reductor
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/icza/bitio"
+)
+
+func Test_bitReader(t *testing.T) {
+	widths := []uint{1, 7, 64, 3, 0, 13, 57, 8, 33, 1, 56, 2}
+	var buf bytes.Buffer
+	w := bitio.NewWriter(&buf)
+	var want []uint64
+	for i, n := range widths {
+		v := uint64(0x9e3779b97f4a7c15) * uint64(i+1)
+		if n < 64 {
+			v &= 1<<n - 1
+		}
+		want = append(want, v)
+		w.WriteBits(v, uint8(n))
+	}
+	w.Close()
+	// Reading one byte at a time exercises the slow refill path.
+	for name, r := range map[string]io.Reader{
+		"Buffered": bytes.NewReader(buf.Bytes()),
+		"One byte": iotest.OneByteReader(bytes.NewReader(buf.Bytes())),
+	} {
+		t.Run(name, func(t *testing.T) {
+			br := newBitReader(r)
+			var count int64
+			for i, n := range widths {
+				got, err := br.readBits(n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want[i] {
+					t.Errorf("unexpected %d bit value (got %#x want %#x)", n, got, want[i])
+				}
+				count += int64(n)
+			}
+			if br.count != count {
+				t.Errorf("unexpected count (got %d want %d)", br.count, count)
+			}
+			pad := uint(-count & 7)
+			if _, err := br.readBits(pad); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := br.readBits(1); err != io.EOF {
+				t.Errorf("unexpected error (got %v want %v)", err, io.EOF)
+			}
+		})
+	}
+}
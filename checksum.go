@@ -0,0 +1,86 @@
+package reductor
+
+import (
+	"errors"
+	"hash"
+	"hash/crc32"
+)
+
+// ErrChecksum is returned when decompressed data does not match the
+// checksum recorded in the stream.
+var ErrChecksum = errors.New("reductor: checksum mismatch")
+
+// Checksum selects the algorithm used to checksum the uncompressed content.
+type Checksum byte
+
+const (
+	// ChecksumCRC32C is CRC-32 with the Castagnoli polynomial. It is the default.
+	ChecksumCRC32C Checksum = iota
+	// ChecksumXXH64 is the 64-bit xxHash.
+	ChecksumXXH64
+	// ChecksumNone disables checksums.
+	ChecksumNone
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func (c Checksum) valid() bool {
+	return c <= ChecksumNone
+}
+
+// newHash returns a hash for c, or nil for ChecksumNone.
+func (c Checksum) newHash() hash.Hash {
+	switch c {
+	case ChecksumCRC32C:
+		return crc32.New(castagnoli)
+	case ChecksumXXH64:
+		return newXXH64()
+	}
+	return nil
+}
+
+// size returns the size of checksums computed with c in bytes.
+func (c Checksum) size() int {
+	if h := c.newHash(); h != nil {
+		return h.Size()
+	}
+	return 0
+}
+
+// Checksum kinds as recorded in header flags. They differ from Checksum
+// so that the zero value of Options selects a checksum, while a header
+// without checksum flags has none.
+const (
+	checksumFlagNone   = 0
+	checksumFlagCRC32C = 1
+	checksumFlagXXH64  = 2
+)
+
+func (c Checksum) flag() byte {
+	switch c {
+	case ChecksumCRC32C:
+		return checksumFlagCRC32C
+	case ChecksumXXH64:
+		return checksumFlagXXH64
+	}
+	return checksumFlagNone
+}
+
+func checksumFromFlag(f byte) (Checksum, bool) {
+	switch f {
+	case checksumFlagNone:
+		return ChecksumNone, true
+	case checksumFlagCRC32C:
+		return ChecksumCRC32C, true
+	case checksumFlagXXH64:
+		return ChecksumXXH64, true
+	}
+	return 0, false
+}
+
+// blockChecksum returns the checksum stored after every block of a stream
+// with checksums enabled. It covers decoded data, so a corrupted block is
+// detected before any of it is returned to the caller.
+func blockChecksum(p []byte) uint32 {
+	return crc32.Checksum(p, castagnoli)
+}
@@ -0,0 +1,81 @@
+package reductor
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func Test_xxh64(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  uint64
+	}{
+		{input: "", want: 0xef46db3751d8e999},
+		{input: "a", want: 0xd24ec4f1a98c6e5b},
+		{input: "abc", want: 0x44bc2cf5ad770999},
+		{input: "Nobody inspects the spammish repetition", want: 0xfbcea83c8a378bf1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			// Feed input in pieces to exercise buffering of partial stripes.
+			d := newXXH64()
+			for p := []byte(tt.input); len(p) > 0; p = p[min(len(p), 3):] {
+				d.Write(p[:min(len(p), 3)])
+			}
+			if got := d.Sum64(); got != tt.want {
+				t.Errorf("unexpected hash (got %#x want %#x)", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_DecompressDetectsCorruption(t *testing.T) {
+	input := bytes.Repeat([]byte("Nothing in io.go detects corruption. "), 50)
+	for _, checksum := range []Checksum{ChecksumCRC32C, ChecksumXXH64} {
+		var compressed bytes.Buffer
+		if err := Compress(&compressed, bytes.NewReader(input), Options{Checksum: checksum}); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < compressed.Len()*8; i++ {
+			corrupted := append([]byte{}, compressed.Bytes()...)
+			corrupted[i/8] ^= 1 << (i % 8)
+			var got bytes.Buffer
+			err := Decompress(&got, bytes.NewReader(corrupted))
+			if err == nil && !bytes.Equal(got.Bytes(), input) {
+				t.Fatalf("checksum %d: flipping bit %d produced wrong output without an error", checksum, i)
+			}
+		}
+	}
+}
+
+func Test_DecompressChecksumMismatch(t *testing.T) {
+	input := []byte("abcdefgh")
+	var compressed bytes.Buffer
+	if err := Compress(&compressed, bytes.NewReader(input), Options{Checksum: ChecksumXXH64}); err != nil {
+		t.Fatal(err)
+	}
+	// The content checksum is at the very end of the stream.
+	corrupted := compressed.Bytes()
+	corrupted[len(corrupted)-1] ^= 1
+	err := Decompress(&bytes.Buffer{}, bytes.NewReader(corrupted))
+	if !errors.Is(err, ErrChecksum) {
+		t.Errorf("unexpected error (got %v want %v)", err, ErrChecksum)
+	}
+}
+
+func Test_HeaderChecksum(t *testing.T) {
+	var compressed bytes.Buffer
+	if err := Compress(&compressed, bytes.NewReader([]byte("abc")), Options{HeaderChecksum: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewReader(bytes.NewReader(compressed.Bytes())); err != nil {
+		t.Fatalf("valid header rejected: %v", err)
+	}
+	corrupted := compressed.Bytes()
+	// Flip a bit of the min-match field.
+	corrupted[6] ^= 1
+	if _, err := NewReader(bytes.NewReader(corrupted)); !errors.Is(err, ErrChecksum) {
+		t.Errorf("unexpected error (got %v want %v)", err, ErrChecksum)
+	}
+}
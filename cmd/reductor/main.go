@@ -0,0 +1,304 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antoniszczepanik/reductor"
+)
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] <filename>\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	var (
+		err                            error
+		minMatch, maxMatch, searchSize uint
+		chainDepth, maxCodeLength      uint
+		longWindow, threads            uint
+	)
+
+	mode := flag.Bool("compress", true, "run the program in compression mode")
+	name := flag.String("name", "", "name for the file with compressed data")
+	levels := make([]*bool, reductor.LevelUltra+1)
+	for level := reductor.MinLevel; level <= reductor.MaxLevel; level++ {
+		usage := fmt.Sprintf("compression level %d", level)
+		switch level {
+		case reductor.MinLevel:
+			usage = "fastest compression level; levels set match-finder, parser, max-match, search-size and chain-depth unless they are given"
+		case reductor.MaxLevel:
+			usage = "best compression level below -ultra"
+		}
+		levels[level] = flag.Bool(strconv.Itoa(level), false, usage)
+	}
+	levels[reductor.LevelUltra] = flag.Bool("ultra", false, "compression level beyond 9, with a 16 MiB search window")
+	flag.UintVar(&minMatch, "min-match", reductor.DefaultMinMatch, "minimum match size for LZ algorithm")
+	flag.UintVar(&maxMatch, "max-match", reductor.DefaultMaxMatch, "maximum match size for LZ algorithm (upper limit is 65535)")
+	flag.UintVar(&searchSize, "search-size", reductor.DefaultSearchSize, fmt.Sprintf("size of the search window of LZ algorithm (upper limit is %d)", reductor.MaxSearchSize))
+	flag.UintVar(&chainDepth, "chain-depth", reductor.DefaultChainDepth, "number of earlier positions checked for every LZ match (upper limit is 65535)")
+	flag.UintVar(&maxCodeLength, "max-code-length", reductor.DefaultMaxCodeLength, "maximum length of huffman codes in bits")
+	flag.UintVar(&longWindow, "long-window", 0, fmt.Sprintf("enable long-distance matching of repeats up to this many bytes apart, e.g. duplicated files in backups (upper limit is %d)", reductor.MaxLongWindow))
+	matchFinder := flag.String("match-finder", "hc", "LZ match finder: hc (hash chain) or bt (binary tree, slower, better matches)")
+	parser := flag.String("parser", "lazy", "LZ parser: greedy, lazy, lazy2 (two-step lazy) or optimal (slowest, best ratio)")
+	checksum := flag.String("checksum", "crc32c", "checksum of the original content: crc32c, xxh64 or none")
+	headerChecksum := flag.Bool("header-checksum", false, "add a checksum of the header")
+	flag.UintVar(&threads, "threads", 0, "compress or decompress this many blocks in parallel, each with its own matches; 0 compresses sequentially")
+	primeWindow := flag.Bool("prime-window", false, "let blocks compressed in parallel find matches in the input preceding them")
+	index := flag.Bool("index", false, "add an index of blocks compressed in parallel, so that they can be decompressed in parallel")
+	dictPath := flag.String("dict", "", "preset dictionary file, e.g. typical messages, which helps small inputs; it is needed again to decompress")
+	byteRange := flag.String("range", "", "decompress only bytes start:end of the content, e.g. 1000:2000 or 1000:, which requires an index")
+
+	// Diagnostic options.
+	verbose := flag.Bool("verbose", false, "display log messages")
+	graphvizPath := flag.String("graphviz", "", "write graphviz huffman tree representation to file")
+	lzPath := flag.String("lz", "", "write lz representation to file")
+	cpuProfilePath := flag.String("cpuprofile", "", "write cpu profile to file")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		Usage()
+	}
+	filePath := flag.Arg(0)
+
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+	log.Printf("Running %s in verbose mode\n", os.Args[0])
+
+	if minMatch > 255 || maxMatch > 65535 || searchSize > reductor.MaxSearchSize || chainDepth > 65535 || maxCodeLength > 255 || longWindow > reductor.MaxLongWindow {
+		fmt.Fprintln(os.Stderr, "min-match, max-match, search-size, chain-depth, max-code-length or long-window out of range")
+		Usage()
+	}
+
+	// Run and save a cpuprofile.
+	if *cpuProfilePath != "" {
+		log.Printf("Will create cpu profile: %s\n", *cpuProfilePath)
+		f, err := os.Create(*cpuProfilePath)
+		if err != nil {
+			fatal(err)
+		}
+		pprof.StartCPUProfile(f)
+		defer pprof.StopCPUProfile()
+	}
+
+	opts := reductor.Options{
+		MinMatch:       byte(minMatch),
+		LongWindow:     uint32(longWindow),
+		MaxCodeLength:  byte(maxCodeLength),
+		HeaderChecksum: *headerChecksum,
+		Concurrency:    int(threads),
+		PrimeWindow:    *primeWindow,
+		Index:          *index,
+	}
+	for level, set := range levels {
+		if set == nil || !*set {
+			continue
+		}
+		if opts.Level != 0 {
+			fmt.Fprintln(os.Stderr, "more than one compression level given")
+			Usage()
+		}
+		opts.Level = level
+	}
+	// Options preset by a level are left unset unless given explicitly, so
+	// that the level fills them in.
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if opts.Level == 0 || set["max-match"] {
+		opts.MaxMatch = uint16(maxMatch)
+	}
+	if opts.Level == 0 || set["search-size"] {
+		opts.SearchSize = uint32(searchSize)
+	}
+	if opts.Level == 0 || set["chain-depth"] {
+		opts.ChainDepth = uint16(chainDepth)
+	}
+	if opts.Level == 0 || set["match-finder"] {
+		switch *matchFinder {
+		case "hc":
+			opts.MatchFinder = reductor.MatchFinderHashChain
+		case "bt":
+			opts.MatchFinder = reductor.MatchFinderBinaryTree
+		default:
+			fmt.Fprintf(os.Stderr, "unknown match finder %q\n", *matchFinder)
+			Usage()
+		}
+	}
+	if opts.Level == 0 || set["parser"] {
+		switch *parser {
+		case "greedy":
+			opts.Parser = reductor.ParserGreedy
+		case "lazy":
+			opts.Parser = reductor.ParserLazy
+		case "lazy2":
+			opts.Parser = reductor.ParserLazy2
+		case "optimal":
+			opts.Parser = reductor.ParserOptimal
+		default:
+			fmt.Fprintf(os.Stderr, "unknown parser %q\n", *parser)
+			Usage()
+		}
+	}
+	switch *checksum {
+	case "crc32c":
+		opts.Checksum = reductor.ChecksumCRC32C
+	case "xxh64":
+		opts.Checksum = reductor.ChecksumXXH64
+	case "none":
+		opts.Checksum = reductor.ChecksumNone
+	default:
+		fmt.Fprintf(os.Stderr, "unknown checksum %q\n", *checksum)
+		Usage()
+	}
+
+	// Open Graphviz writer.
+	if *graphvizPath != "" {
+		log.Printf("Will create graph of huffman tree: %s\n", *graphvizPath)
+		opts.Graphviz, err = os.Create(*graphvizPath)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	// Open LZ writer.
+	if *lzPath != "" {
+		log.Printf("Will create LZ representation: %s\n", *lzPath)
+		opts.LZ, err = os.Create(*lzPath)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	var dict []byte
+	if *dictPath != "" {
+		log.Printf("Using dictionary: %s\n", *dictPath)
+		if dict, err = ioutil.ReadFile(*dictPath); err != nil {
+			fatal(err)
+		}
+		opts.Dictionary = dict
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		fatal(err)
+	}
+	defer f.Close()
+
+	if *mode {
+		log.Printf("Compress: %s\n", filePath)
+		// Zero values are left to the level.
+		log.Printf("Config: level=%d, match-finder=%v, parser=%v, min-match=%d, max-match=%d, search-size=%d, chain-depth=%d, long-window=%d, threads=%d\n", opts.Level, opts.MatchFinder, opts.Parser, opts.MinMatch, opts.MaxMatch, opts.SearchSize, opts.ChainDepth, opts.LongWindow, opts.Concurrency)
+		fileSize := getFileSize(filePath)
+		log.Printf("Input size(bytes): %d\n", fileSize)
+		if *name == "" {
+			*name = filePath + ".reduced"
+		}
+		target := create(*name)
+		start := time.Now()
+		if err := reductor.Compress(target, f, opts); err != nil {
+			fatal(err)
+		}
+		closeFile(target)
+		compressedFileSize := getFileSize(*name)
+		log.Printf("Time elapsed: %s\n", time.Since(start))
+		log.Printf("Compression ratio: %.2f\n", float64(fileSize)/float64(compressedFileSize))
+	} else {
+		log.Printf("Decompress: %s\n", filePath)
+		if *name == "" {
+			// Strip off ".reduced" suffix if present.
+			if strings.HasSuffix(strings.ToLower(filePath), ".reduced") {
+				*name = filePath[:len(filePath)-8] + ".unreduced"
+			} else {
+				*name = filePath + ".unreduced"
+			}
+		}
+		log.Printf("Writing decompressed data to %s\n", *name)
+		sink := create(*name)
+		start := time.Now()
+		decompress := func() error { return reductor.DecompressDict(sink, f, dict) }
+		// Streams compressed with a dictionary have no index to decode
+		// in parallel.
+		if threads > 0 && dict == nil {
+			decompress = func() error { return reductor.DecompressParallel(sink, f, getFileSize(filePath), int(threads)) }
+		}
+		if *byteRange != "" {
+			decompress = func() error { return decompressRange(sink, f, getFileSize(filePath), *byteRange) }
+		}
+		if err := decompress(); err != nil {
+			// Do not leave unverified output behind.
+			sink.Close()
+			os.Remove(*name)
+			fatal(err)
+		}
+		closeFile(sink)
+		log.Printf("Time elapsed: %s\n", time.Since(start))
+	}
+}
+
+// decompressRange writes bytes start:end of the content of the size bytes
+// of compressed data in ra to w. A missing end stands for the end of the
+// content.
+func decompressRange(w io.Writer, ra io.ReaderAt, size int64, byteRange string) error {
+	i := strings.Index(byteRange, ":")
+	if i < 0 {
+		return fmt.Errorf("invalid range %q", byteRange)
+	}
+	zr, err := reductor.NewReaderAt(ra, size)
+	if err != nil {
+		return err
+	}
+	start, err := strconv.ParseInt(byteRange[:i], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid range %q", byteRange)
+	}
+	end := zr.Size()
+	if byteRange[i+1:] != "" {
+		if end, err = strconv.ParseInt(byteRange[i+1:], 10, 64); err != nil {
+			return fmt.Errorf("invalid range %q", byteRange)
+		}
+	}
+	if start < 0 || end < start {
+		return fmt.Errorf("invalid range %q", byteRange)
+	}
+	_, err = io.Copy(w, io.NewSectionReader(zr, start, end-start))
+	return err
+}
+
+func create(name string) *os.File {
+	f, err := os.Create(name)
+	if err != nil {
+		fatal(err)
+	}
+	return f
+}
+
+func closeFile(f io.Closer) {
+	if err := f.Close(); err != nil {
+		fatal(err)
+	}
+}
+
+func getFileSize(filePath string) int64 {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		fatal(err)
+	}
+	return fi.Size()
+}
+
+// fatal reports err regardless of verbosity and exits.
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+	os.Exit(1)
+}
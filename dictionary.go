@@ -0,0 +1,22 @@
+package reductor
+
+import (
+	"errors"
+	"hash/crc32"
+)
+
+// ErrDictionary is returned when a stream is decompressed without the
+// dictionary it was compressed with.
+var ErrDictionary = errors.New("reductor: dictionary mismatch")
+
+// DictionaryID returns the ID recorded in streams compressed with dict,
+// which tells dictionaries apart. It is the CRC-32C of dict.
+func DictionaryID(dict []byte) uint32 {
+	return crc32.Checksum(dict, castagnoli)
+}
+
+// dictionaryTail returns the end of dict that matches may reach, given a
+// window size.
+func dictionaryTail(dict []byte, window int) []byte {
+	return dict[max(0, len(dict)-window):]
+}
@@ -0,0 +1,102 @@
+package reductor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// jsonMessages returns n small JSON messages sharing their structure.
+func jsonMessages(n int) [][]byte {
+	messages := make([][]byte, n)
+	for i := range messages {
+		messages[i] = []byte(fmt.Sprintf(`{"id":%d,"type":"order.created","customer":{"id":%d,"country":"PL"},"items":[{"sku":"SKU-%04d","quantity":%d}],"status":"pending"}`, 1000+i, 7*i, 13*i%10000, i%5+1))
+	}
+	return messages
+}
+
+func Test_CompressDictionary(t *testing.T) {
+	messages := jsonMessages(20)
+	var dict []byte
+	for _, m := range jsonMessages(40)[20:] {
+		dict = append(dict, m...)
+	}
+	var tests = []struct {
+		name string
+		opts Options
+	}{
+		{name: "Sequential", opts: Options{}},
+		{name: "Window shorter than dictionary", opts: Options{SearchSize: 512}},
+		{name: "Long window", opts: Options{LongWindow: 1 << 16}},
+		{name: "Primed window", opts: Options{Concurrency: 2, PrimeWindow: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, m := range messages {
+				var plain, compressed bytes.Buffer
+				if err := Compress(&plain, bytes.NewReader(m), tt.opts); err != nil {
+					t.Fatal(err)
+				}
+				opts := tt.opts
+				opts.Dictionary = dict
+				if err := Compress(&compressed, bytes.NewReader(m), opts); err != nil {
+					t.Fatal(err)
+				}
+				if compressed.Len() >= plain.Len() {
+					t.Errorf("dictionary does not help (got %d bytes, %d without it)", compressed.Len(), plain.Len())
+				}
+				var got bytes.Buffer
+				if err := DecompressDict(&got, &compressed, dict); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.Bytes(), m) {
+					t.Fatalf("roundtrip mismatch (got %q want %q)", got.Bytes(), m)
+				}
+			}
+		})
+	}
+}
+
+func Test_DecompressDictionaryMismatch(t *testing.T) {
+	dict := bytes.Repeat([]byte(`{"status":"pending"}`), 10)
+	input := []byte(`{"id":1,"status":"pending"}`)
+	var tests = []struct {
+		name     string
+		compress []byte
+		dict     []byte
+		err      error
+	}{
+		{name: "Matching dictionary", compress: dict, dict: dict},
+		{name: "Missing dictionary", compress: dict, err: ErrDictionary},
+		{name: "Different dictionary", compress: dict, dict: dict[1:], err: ErrDictionary},
+		{name: "Stream without dictionary", dict: dict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var compressed, got bytes.Buffer
+			if err := Compress(&compressed, bytes.NewReader(input), Options{Dictionary: tt.compress}); err != nil {
+				t.Fatal(err)
+			}
+			err := DecompressDict(&got, &compressed, tt.dict)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("unexpected error (got %v want %v)", err, tt.err)
+			}
+			if err == nil && !bytes.Equal(got.Bytes(), input) {
+				t.Errorf("roundtrip mismatch (got %q want %q)", got.Bytes(), input)
+			}
+		})
+	}
+}
+
+func Test_BytesToValuesDict(t *testing.T) {
+	dict := []byte("the quick brown fox jumps over the lazy dog")
+	input := []byte("a lazy dog jumps over the quick brown fox")
+	values := BytesToValuesDict(input, dict, 4, 255, 4096)
+	if got := ValuesToBytesDict(values, dict); !bytes.Equal(got, input) {
+		t.Fatalf("roundtrip mismatch (got %q want %q)", got, input)
+	}
+	if plain := BytesToValues(input, 4, 255, 4096); len(values) >= len(plain) {
+		t.Errorf("dictionary does not help (got %d values, %d without it)", len(values), len(plain))
+	}
+}
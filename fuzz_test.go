@@ -0,0 +1,42 @@
+//go:build go1.18
+// +build go1.18
+
+package reductor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// FuzzDecompress checks that no input makes Decompress panic.
+// Valid streams of every kind serve as the seed corpus.
+func FuzzDecompress(f *testing.F) {
+	for _, path := range []string{
+		"testdata/legacy.txt.reduced",
+		"testdata/legacy.txt.v1.reduced",
+		"testdata/legacy.txt.v2.reduced",
+	} {
+		compressed, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(compressed)
+	}
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 20)
+	for _, opts := range []Options{
+		{},
+		{Checksum: ChecksumNone, HeaderChecksum: true},
+		{MinMatch: 1, Parser: ParserOptimal},
+		{Index: true, Concurrency: 2},
+	} {
+		var compressed bytes.Buffer
+		if err := Compress(&compressed, bytes.NewReader(input), opts); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(compressed.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Decompress(ioutil.Discard, bytes.NewReader(data))
+	})
+}
@@ -0,0 +1,218 @@
+package reductor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// ErrHeader is returned when the input is not a reductor stream or its
+// header is invalid.
+var ErrHeader = errors.New("reductor: invalid header")
+
+// magic starts every reductor stream. Its third byte, read as a code
+// length by the headerless legacy format, is larger than any code the
+// legacy encoder could produce, so the two formats cannot be confused.
+var magic = [4]byte{'R', 'D', 'C', 'R'}
+
+// formatVersion is the version of the format written by Writer. Reader
+// also supports version 1, which limited match lengths to 255 and the
+// search size to 65535, and had no repeat symbols, stored blocks or
+// reused codes.
+const formatVersion = 2
+
+// Header flags.
+const (
+	// flagContentSize marks that the header carries the uncompressed size.
+	flagContentSize = 1 << 0
+	// Bits 1-2 select the content checksum, see checksumFlagNone and others.
+	flagChecksumShift = 1
+	flagChecksumMask  = 3 << flagChecksumShift
+	// flagHeaderChecksum marks that the header ends with its own CRC-32C.
+	flagHeaderChecksum = 1 << 3
+	// flagIndex marks that the stream ends with an index of blocks.
+	flagIndex = 1 << 4
+	// flagDictionary marks that the header carries the ID of the preset
+	// dictionary the stream was compressed with.
+	flagDictionary = 1 << 5
+
+	knownFlags = flagContentSize | flagChecksumMask | flagHeaderChecksum | flagIndex | flagDictionary
+)
+
+// header precedes the blocks of a stream:
+//
+//	magic           4 bytes
+//	version         1 byte
+//	flags           1 byte
+//	min-match       1 byte
+//	max-match       uvarint
+//	window          uvarint, the largest distance of a match
+//	content size    uvarint, present if flagContentSize is set
+//	dictionary ID   4 bytes, big endian, present if flagDictionary is set
+//	header checksum 4 bytes, CRC-32C of all of the above, present if
+//	                flagHeaderChecksum is set
+//
+// Version 1 headers store max-match in 1 byte and the window in 2 bytes,
+// big endian.
+type header struct {
+	version     byte
+	flags       byte
+	minMatch    byte
+	maxMatch    uint16
+	window      uint32
+	contentSize uint64
+	dictionary  uint32
+}
+
+func newHeader(opts Options) header {
+	h := header{
+		version:  formatVersion,
+		flags:    opts.Checksum.flag() << flagChecksumShift,
+		minMatch: opts.MinMatch,
+		maxMatch: opts.MaxMatch,
+		window:   opts.window(),
+	}
+	if opts.ContentSize > 0 {
+		h.flags |= flagContentSize
+		h.contentSize = uint64(opts.ContentSize)
+	}
+	if opts.HeaderChecksum {
+		h.flags |= flagHeaderChecksum
+	}
+	if opts.Index {
+		h.flags |= flagIndex
+	}
+	if len(opts.Dictionary) > 0 {
+		h.flags |= flagDictionary
+		h.dictionary = DictionaryID(opts.Dictionary)
+	}
+	return h
+}
+
+// checksum returns the content checksum algorithm of the stream.
+func (h header) checksum() Checksum {
+	c, _ := checksumFromFlag(h.flags & flagChecksumMask >> flagChecksumShift)
+	return c
+}
+
+func (h header) marshal() []byte {
+	b := make([]byte, 7, 7+3*binary.MaxVarintLen64+8)
+	copy(b, magic[:])
+	b[4] = h.version
+	b[5] = h.flags
+	b[6] = h.minMatch
+	var buf [binary.MaxVarintLen64]byte
+	b = append(b, buf[:binary.PutUvarint(buf[:], uint64(h.maxMatch))]...)
+	b = append(b, buf[:binary.PutUvarint(buf[:], uint64(h.window))]...)
+	if h.flags&flagContentSize != 0 {
+		b = append(b, buf[:binary.PutUvarint(buf[:], h.contentSize)]...)
+	}
+	if h.flags&flagDictionary != 0 {
+		var id [4]byte
+		binary.BigEndian.PutUint32(id[:], h.dictionary)
+		b = append(b, id[:]...)
+	}
+	if h.flags&flagHeaderChecksum != 0 {
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], crc32.Checksum(b, castagnoli))
+		b = append(b, sum[:]...)
+	}
+	return b
+}
+
+// readHeader reads a header, including the magic, from r.
+func readHeader(r io.ByteReader) (header, error) {
+	var (
+		h   header
+		buf [7]byte
+		err error
+	)
+	// Keep the raw header around to verify its checksum.
+	rr := &recordingByteReader{r: r}
+	for i := range buf {
+		if buf[i], err = rr.ReadByte(); err != nil {
+			return h, noEOF(err)
+		}
+	}
+	if [4]byte{buf[0], buf[1], buf[2], buf[3]} != magic {
+		return h, ErrHeader
+	}
+	h.version = buf[4]
+	if h.version < 1 || h.version > formatVersion {
+		return h, fmt.Errorf("%w: unsupported version %d", ErrHeader, h.version)
+	}
+	h.flags = buf[5]
+	if h.flags&^knownFlags != 0 {
+		return h, fmt.Errorf("%w: unknown flags %#x", ErrHeader, h.flags)
+	}
+	if _, ok := checksumFromFlag(h.flags & flagChecksumMask >> flagChecksumShift); !ok {
+		return h, fmt.Errorf("%w: unknown checksum", ErrHeader)
+	}
+	h.minMatch = buf[6]
+	if h.version == 1 {
+		var v1 [3]byte
+		for i := range v1 {
+			if v1[i], err = rr.ReadByte(); err != nil {
+				return h, noEOF(err)
+			}
+		}
+		h.maxMatch = uint16(v1[0])
+		h.window = uint32(binary.BigEndian.Uint16(v1[1:]))
+	} else {
+		maxMatch, err := binary.ReadUvarint(rr)
+		if err != nil {
+			return h, noEOF(err)
+		}
+		window, err := binary.ReadUvarint(rr)
+		if err != nil {
+			return h, noEOF(err)
+		}
+		if maxMatch > maxLength || window > maxDistance {
+			return h, fmt.Errorf("%w: max-match %d or window %d out of range", ErrHeader, maxMatch, window)
+		}
+		h.maxMatch, h.window = uint16(maxMatch), uint32(window)
+	}
+	if h.flags&flagContentSize != 0 {
+		if h.contentSize, err = binary.ReadUvarint(rr); err != nil {
+			return h, noEOF(err)
+		}
+	}
+	if h.flags&flagDictionary != 0 {
+		var id [4]byte
+		for i := range id {
+			if id[i], err = rr.ReadByte(); err != nil {
+				return h, noEOF(err)
+			}
+		}
+		h.dictionary = binary.BigEndian.Uint32(id[:])
+	}
+	if h.flags&flagHeaderChecksum != 0 {
+		want := crc32.Checksum(rr.buf, castagnoli)
+		var sum [4]byte
+		for i := range sum {
+			if sum[i], err = r.ReadByte(); err != nil {
+				return h, noEOF(err)
+			}
+		}
+		if binary.BigEndian.Uint32(sum[:]) != want {
+			return h, fmt.Errorf("%w: header", ErrChecksum)
+		}
+	}
+	return h, nil
+}
+
+// recordingByteReader keeps a copy of all bytes read through it.
+type recordingByteReader struct {
+	r   io.ByteReader
+	buf []byte
+}
+
+func (rr *recordingByteReader) ReadByte() (byte, error) {
+	b, err := rr.r.ReadByte()
+	if err == nil {
+		rr.buf = append(rr.buf, b)
+	}
+	return b, err
+}
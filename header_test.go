@@ -0,0 +1,169 @@
+package reductor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/icza/bitio"
+)
+
+func Test_headerRoundtrip(t *testing.T) {
+	var tests = []struct {
+		name string
+		opts Options
+	}{
+		{name: "Defaults", opts: Options{}},
+		{name: "Content size", opts: Options{ContentSize: 1 << 40}},
+		{name: "Custom options", opts: Options{MinMatch: 3, MaxMatch: 17, SearchSize: 65535, ContentSize: 1}},
+		{name: "Large window", opts: Options{MaxMatch: 65535, SearchSize: MaxSearchSize}},
+		{name: "Dictionary", opts: Options{Dictionary: []byte("dictionary"), HeaderChecksum: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := newHeader(tt.opts.withDefaults())
+			got, err := readHeader(bufio.NewReader(bytes.NewReader(want.marshal())))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("unexpected header (got %+v want %+v)", got, want)
+			}
+		})
+	}
+}
+
+func Test_NewReaderRejectsInvalidInput(t *testing.T) {
+	valid := newHeader(Options{}.withDefaults()).marshal()
+	badVersion := append([]byte{}, valid...)
+	badVersion[4] = formatVersion + 1
+	badFlags := append([]byte{}, valid...)
+	badFlags[5] = 0x80
+	var tests = []struct {
+		name  string
+		input []byte
+	}{
+		{name: "Empty input", input: []byte("")},
+		{name: "Plain text", input: []byte("hello world, this is not compressed")},
+		{name: "Truncated header", input: valid[:7]},
+		{name: "Unsupported version", input: badVersion},
+		{name: "Unknown flags", input: badFlags},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReader(bytes.NewReader(tt.input))
+			if !errors.Is(err, ErrHeader) && err != io.ErrUnexpectedEOF {
+				t.Errorf("unexpected error (got %v want %v)", err, ErrHeader)
+			}
+		})
+	}
+}
+
+func Test_ReaderContentSizeMismatch(t *testing.T) {
+	input := []byte("abcdabcdabcd")
+	var compressed bytes.Buffer
+	if err := Compress(&compressed, bytes.NewReader(input), Options{ContentSize: int64(len(input))}); err != nil {
+		t.Fatal(err)
+	}
+	// Replace the recorded size (the last header byte) with a larger one.
+	corrupted := compressed.Bytes()
+	corrupted[11] = byte(len(input) + 1)
+	zr, err := NewReader(bytes.NewReader(corrupted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, zr); err != io.ErrUnexpectedEOF {
+		t.Errorf("unexpected error (got %v want %v)", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func Test_WriterContentSizeMismatch(t *testing.T) {
+	zw, err := NewWriter(ioutil.Discard, Options{ContentSize: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err == nil {
+		t.Error("expected an error closing Writer before ContentSize bytes were written")
+	}
+}
+
+func Test_DecompressLegacy(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/legacy.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name string
+		path string
+	}{
+		// Written by the encoder that predates the container format.
+		{name: "Headerless", path: "testdata/legacy.txt.reduced"},
+		// Written by the encoder limited to 255 byte matches and 64 KiB
+		// windows.
+		{name: "Version 1", path: "testdata/legacy.txt.v1.reduced"},
+		// Written by the encoder of the current format, which catches changes
+		// that would break files already written with it.
+		{name: "Version 2", path: "testdata/legacy.txt.v2.reduced"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed, err := ioutil.ReadFile(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			if err := Decompress(&got, bytes.NewReader(compressed)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("unexpected output (got %d bytes want %d bytes)", got.Len(), len(want))
+			}
+		})
+	}
+}
+
+func Test_DecompressLegacyTableCorrupt(t *testing.T) {
+	// overlapping codes satisfy Kraft's equality, but the 10 bit code of
+	// symbol 10 is a prefix of the 11 bit codes.
+	var overlapping []Code
+	for l := 1; l <= 10; l++ {
+		overlapping = append(overlapping, Code{c: 1, bits: byte(l)})
+	}
+	overlapping[9].c = 0
+	overlapping = append(overlapping, Code{c: 1, bits: 11}, Code{c: 0, bits: 11})
+	var tests = []struct {
+		name  string
+		syms  []int
+		codes []Code
+	}{
+		{name: "Not prefix-free", syms: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}, codes: overlapping},
+		{name: "Duplicate code", syms: []int{'a', 'b', 'c'}, codes: []Code{{c: 0, bits: 1}, {c: 1, bits: 1}, {c: 1, bits: 1}}},
+		{name: "Duplicate symbol", syms: []int{'a', 'a'}, codes: []Code{{c: 0, bits: 1}, {c: 1, bits: 1}}},
+		{name: "Incomplete", syms: []int{'a', 'b'}, codes: []Code{{c: 0, bits: 1}, {c: 2, bits: 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream bytes.Buffer
+			w := bitio.NewWriter(&stream)
+			w.TryWriteBits(uint64(len(tt.codes)-1), 8)
+			for i, c := range tt.codes {
+				w.TryWriteBits(uint64(tt.syms[i]), 8)
+				w.TryWriteBits(uint64(c.bits), 8)
+				w.TryWriteBits(c.c, c.bits)
+			}
+			w.TryWriteBits(0, 64)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := Decompress(ioutil.Discard, &stream); !errors.Is(err, ErrHeader) {
+				t.Errorf("unexpected error (got %v want %v)", err, ErrHeader)
+			}
+		})
+	}
+}
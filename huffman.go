@@ -1,22 +1,23 @@
-package main
+package reductor
 
 import (
 	"container/heap"
 	"fmt"
 	"io"
+	"sort"
 )
 
-type Node struct {
-	value       byte
+type node struct {
+	value       int
 	freq        int
-	Left, Right *Node
+	Left, Right *node
 	isLeaf      bool
 	// Used only to represent nodes in graphviz format.
 	id int
 }
 
-func NewNode(id int, val byte, freq int, l, r *Node) Node {
-	return Node{
+func newNode(id int, val int, freq int, l, r *node) node {
+	return node{
 		id:    id,
 		value: val,
 		freq:  freq,
@@ -25,14 +26,14 @@ func NewNode(id int, val byte, freq int, l, r *Node) Node {
 	}
 }
 
-func (n Node) DumpGraphviz(w io.Writer) {
+func (n node) DumpGraphviz(w io.Writer) {
 	w.Write([]byte("Digraph g {\n"))
 	w.Write([]byte(n.getGraphviz()))
 	w.Write([]byte("}\n"))
 }
 
 // getGraphviz recursively gets graphviz representation of all nodes with root at n.
-func (n Node) getGraphviz() string {
+func (n node) getGraphviz() string {
 	repr := fmt.Sprintf("\t%d[label=\"value=%d freq=%d\"]\n", n.id, n.value, n.freq)
 	if n.Left != nil {
 		repr += fmt.Sprintf("\t%d -> %d[label=\"0\"]\n", n.id, n.Left.id)
@@ -45,13 +46,20 @@ func (n Node) getGraphviz() string {
 	return repr
 }
 
-type PriorityQueue []Node
+type nodeQueue []node
 
-func (pq PriorityQueue) Len() int            { return len(pq) }
-func (pq PriorityQueue) Less(i, j int) bool  { return pq[i].freq < pq[j].freq }
-func (pq PriorityQueue) Swap(i, j int)       { pq[i], pq[j] = pq[j], pq[i] }
-func (pq *PriorityQueue) Push(x interface{}) { *pq = append(*pq, x.(Node)) }
-func (pq *PriorityQueue) Pop() interface{} {
+func (pq nodeQueue) Len() int { return len(pq) }
+func (pq nodeQueue) Less(i, j int) bool {
+	// Ties are broken by id, so that the tree does not depend on the order
+	// nodes happen to be stored in.
+	if pq[i].freq == pq[j].freq {
+		return pq[i].id < pq[j].id
+	}
+	return pq[i].freq < pq[j].freq
+}
+func (pq nodeQueue) Swap(i, j int)       { pq[i], pq[j] = pq[j], pq[i] }
+func (pq *nodeQueue) Push(x interface{}) { *pq = append(*pq, x.(node)) }
+func (pq *nodeQueue) Pop() interface{} {
 	old := *pq
 	n := len(old)
 	x := old[n-1]
@@ -59,8 +67,8 @@ func (pq *PriorityQueue) Pop() interface{} {
 	return x
 }
 
-func (pq *PriorityQueue) RemoveEmpty() PriorityQueue {
-	not_empty_pq := make(PriorityQueue, 0, 255)
+func (pq *nodeQueue) removeEmpty() nodeQueue {
+	not_empty_pq := make(nodeQueue, 0, 255)
 	for _, n := range *pq {
 		if n.freq != 0 {
 			not_empty_pq = append(not_empty_pq, n)
@@ -69,34 +77,76 @@ func (pq *PriorityQueue) RemoveEmpty() PriorityQueue {
 	return not_empty_pq
 }
 
-// constructHuffmanTree creates a tree for values and returns root *Node.
-func constructHuffmanTree(values []Value) Node {
-	freqs := make(PriorityQueue, 256)
-	var i int // i is id counter.
-
-	// Copy values into corresponding spots.
-	for i = 0; i < len(freqs); i++ {
-		freqs[i].value, freqs[i].id, freqs[i].isLeaf = byte(i), i, true
-	}
+// countFreqs returns how many times each symbol of the literal/length and
+// the distance alphabets occurs in values, which make up a block.
+func countFreqs(values []Value) (litLen, dist []int) {
+	litLen = make([]int, litLenSize)
+	dist = make([]int, distSize)
+	reps := newRepHistory()
 	for _, v := range values {
 		if v.IsLiteral {
-			freqs[v.GetLiteralBinary()].freq += 1
+			litLen[v.GetLiteralBinary()] += 1
 		} else {
-			for _, b := range v.GetPointerBinary() {
-				freqs[b].freq += 1
-			}
+			sym, _, _ := bucket(uint32(v.length), lengthMantissa)
+			litLen[256+sym] += 1
+			sym, _, _ = distanceSymbol(v.distance, &reps)
+			dist[sym] += 1
+			reps.update(v.distance)
 		}
 	}
-	freqs = freqs.RemoveEmpty()
+	return litLen, dist
+}
+
+// constructHuffmanTree creates a tree for symbol frequencies and returns
+// its root. Symbols with zero frequency are left out.
+func constructHuffmanTree(symbolFreqs []int) node {
+	freqs := make(nodeQueue, len(symbolFreqs))
+	var i int // i is id counter.
+
+	// Copy frequencies into corresponding spots.
+	for i = 0; i < len(freqs); i++ {
+		freqs[i].value, freqs[i].id, freqs[i].isLeaf = i, i, true
+		freqs[i].freq = symbolFreqs[i]
+	}
+	freqs = freqs.removeEmpty()
+	// Empty input still needs a tree with at least one leaf.
+	if freqs.Len() == 0 {
+		freqs = append(freqs, node{isLeaf: true})
+	}
 	heap.Init(&freqs)
 	for freqs.Len() > 1 {
-		r, l := heap.Pop(&freqs).(Node), heap.Pop(&freqs).(Node)
-		heap.Push(&freqs, NewNode(i, 0, r.freq+l.freq, &l, &r))
+		r, l := heap.Pop(&freqs).(node), heap.Pop(&freqs).(node)
+		heap.Push(&freqs, newNode(i, 0, r.freq+l.freq, &l, &r))
 		i += 1
 	}
-	return heap.Pop(&freqs).(Node)
+	return heap.Pop(&freqs).(node)
+}
+
+// codeLengths returns the depth of every leaf of the tree rooted at root,
+// indexed by symbol, for an alphabet of n symbols. Symbols absent from the
+// tree get 0. A lone leaf gets 1, as a code cannot be empty.
+func codeLengths(root *node, n int) []byte {
+	lengths := make([]byte, n)
+	if root.isLeaf {
+		lengths[root.value] = 1
+		return lengths
+	}
+	var walk func(n *node, depth byte)
+	walk = func(n *node, depth byte) {
+		if n.isLeaf {
+			lengths[n.value] = depth
+			return
+		}
+		walk(n.Left, depth+1)
+		walk(n.Right, depth+1)
+	}
+	walk(root, 0)
+	return lengths
 }
 
+// maxCodeLen is the longest code a Code can hold.
+const maxCodeLen = 64
+
 type Code struct {
 	c    uint64
 	bits byte
@@ -117,28 +167,319 @@ func addBit(c Code, bit bool) Code {
 	}
 }
 
-type CodeTable map[byte]Code
+// huffmanCodeLengths returns code lengths of an optimal prefix code for
+// symbol frequencies, with no code longer than limit bits.
+func huffmanCodeLengths(freqs []int, limit int) []byte {
+	root := constructHuffmanTree(freqs)
+	lengths := codeLengths(&root, len(freqs))
+	for _, l := range lengths {
+		if int(l) > limit {
+			return packageMerge(freqs, limit)
+		}
+	}
+	return lengths
+}
 
-func createCodeTable(root *Node, prefix Code) CodeTable {
-	codeTable := make(CodeTable)
-	lt := make(CodeTable)
-	rt := make(CodeTable)
-	if root.isLeaf {
-		codeTable[root.value] = prefix
-		return codeTable
+// pmItem is either a symbol or a package of two items in packageMerge.
+type pmItem struct {
+	weight      int
+	sym         int
+	left, right *pmItem
+}
+
+// packageMerge returns optimal code lengths for symbol frequencies with no
+// code longer than limit bits, using the package-merge algorithm. limit
+// must be large enough to fit all symbols with non-zero frequency.
+func packageMerge(freqs []int, limit int) []byte {
+	lengths := make([]byte, len(freqs))
+	var leaves []*pmItem
+	for sym, f := range freqs {
+		if f > 0 {
+			leaves = append(leaves, &pmItem{weight: f, sym: sym})
+		}
+	}
+	if len(leaves) <= 1 {
+		for _, leaf := range leaves {
+			lengths[leaf.sym] = 1
+		}
+		return lengths
+	}
+	sort.SliceStable(leaves, func(i, j int) bool { return leaves[i].weight < leaves[j].weight })
+
+	// Each round packages items pairwise and merges packages back with
+	// the leaves. After limit-1 rounds, the cheapest 2n-2 items determine
+	// the code lengths: a symbol's length is the number of them it is in.
+	items := leaves
+	for level := 1; level < limit; level++ {
+		packages := make([]*pmItem, 0, len(items)/2)
+		for i := 0; i+1 < len(items); i += 2 {
+			packages = append(packages, &pmItem{
+				weight: items[i].weight + items[i+1].weight,
+				left:   items[i],
+				right:  items[i+1],
+			})
+		}
+		items = mergeItems(leaves, packages)
+	}
+	var count func(it *pmItem)
+	count = func(it *pmItem) {
+		if it.left == nil {
+			lengths[it.sym]++
+			return
+		}
+		count(it.left)
+		count(it.right)
+	}
+	for _, it := range items[:2*len(leaves)-2] {
+		count(it)
+	}
+	return lengths
+}
+
+// mergeItems merges two lists sorted by weight. Leaves from a go first
+// on ties.
+func mergeItems(a, b []*pmItem) []*pmItem {
+	merged := make([]*pmItem, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0].weight <= b[0].weight {
+			merged, a = append(merged, a[0]), a[1:]
+		} else {
+			merged, b = append(merged, b[0]), b[1:]
+		}
+	}
+	merged = append(merged, a...)
+	return append(merged, b...)
+}
+
+// newCodeTree builds a tree following codes in table, with symbol
+// frequencies in leaves. It is used to visualise the code actually used.
+func newCodeTree(table codeTable, freqs []int) node {
+	id := 0
+	newChild := func() *node {
+		id++
+		return &node{id: id}
+	}
+	root := &node{}
+	for sym, c := range table {
+		if c.bits == 0 {
+			continue
+		}
+		n := root
+		n.freq += freqs[sym]
+		for i := int(c.bits) - 1; i >= 0; i-- {
+			child := &n.Left
+			if c.c>>uint(i)&1 == 1 {
+				child = &n.Right
+			}
+			if *child == nil {
+				*child = newChild()
+			}
+			n = *child
+			n.freq += freqs[sym]
+		}
+		n.value, n.isLeaf = sym, true
 	}
-	if root.Left != nil {
-		lt = createCodeTable(root.Left, addBit(prefix, false))
+	return *root
+}
+
+// codeTable holds a code for every symbol of an alphabet.
+type codeTable []Code
+
+// canonicalCodes assigns canonical Huffman codes to symbols given their
+// code lengths: shorter codes come first and codes of the same length are
+// consecutive in symbol order. This way only lengths need to be stored.
+func canonicalCodes(lengths []byte) codeTable {
+	var count [maxCodeLen + 1]int
+	for _, l := range lengths {
+		count[l]++
 	}
-	if root.Right != nil {
-		rt = createCodeTable(root.Right, addBit(prefix, true))
+	count[0] = 0
+	// next[l] is the next code of length l.
+	var (
+		next [maxCodeLen + 1]uint64
+		code uint64
+	)
+	for l := 1; l <= maxCodeLen; l++ {
+		code = (code + uint64(count[l-1])) << 1
+		next[l] = code
 	}
-	return mergeTables(lt, rt)
+	table := make(codeTable, len(lengths))
+	for sym, l := range lengths {
+		if l != 0 {
+			table[sym] = Code{c: next[l], bits: l}
+			next[l]++
+		}
+	}
+	return table
+}
+
+// Decoding table parameters. Codes up to primaryBits long are resolved
+// with a single lookup, longer ones through a secondary table indexed by
+// up to secondaryBits following bits. Longer codes are decoded bit by bit.
+const (
+	primaryBits   = 10
+	secondaryBits = 8
+)
+
+// Kinds of decoding table entries.
+const (
+	entryInvalid = iota
+	entrySymbol
+	entryLink
+	entrySlow
+)
+
+type decodeEntry struct {
+	// sym is the symbol, or the index of a secondary table for entryLink.
+	sym  uint16
+	bits uint8
+	kind uint8
+}
+
+type decodeTable struct {
+	bits    uint
+	entries []decodeEntry
 }
 
-func mergeTables(a, b CodeTable) CodeTable {
-	for k, v := range b {
-		a[k] = v
+// huffmanDecoder resolves codes through lookup tables.
+type huffmanDecoder struct {
+	primary   decodeTable
+	secondary []decodeTable
+	// slow maps codes too long for the tables to symbols.
+	slow map[Code]int
+}
+
+// newHuffmanDecoder creates a decoder for a prefix code. table does not
+// have to be canonical. Symbols with empty codes are absent. It returns
+// ErrCorrupt if two codes share a table entry, so one is a prefix of the
+// other.
+func newHuffmanDecoder(table codeTable) (*huffmanDecoder, error) {
+	maxLen := 0
+	for _, c := range table {
+		maxLen = max(maxLen, int(c.bits))
+	}
+	d := &huffmanDecoder{}
+	d.primary.bits = uint(max(1, min(maxLen, primaryBits)))
+	d.primary.entries = make([]decodeEntry, 1<<d.primary.bits)
+	pb := d.primary.bits
+
+	// Longest code sharing each primary prefix, to size secondary tables.
+	longest := make([]uint, len(d.primary.entries))
+	for _, c := range table {
+		if uint(c.bits) > pb {
+			prefix := c.c >> (uint(c.bits) - pb)
+			if uint(c.bits) > longest[prefix] {
+				longest[prefix] = uint(c.bits)
+			}
+		}
+	}
+	for prefix, l := range longest {
+		if l == 0 {
+			continue
+		}
+		e := &d.primary.entries[prefix]
+		if l-pb > secondaryBits {
+			e.kind = entrySlow
+			continue
+		}
+		*e = decodeEntry{sym: uint16(len(d.secondary)), bits: uint8(l - pb), kind: entryLink}
+		d.secondary = append(d.secondary, decodeTable{
+			bits:    l - pb,
+			entries: make([]decodeEntry, 1<<(l-pb)),
+		})
+	}
+
+	for sym, c := range table {
+		if c.bits == 0 {
+			continue
+		}
+		l := uint(c.bits)
+		if l <= pb {
+			// Fill every entry starting with the code.
+			start := c.c << (pb - l)
+			if err := fillEntries(d.primary.entries[start:start+1<<(pb-l)], sym, l); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		e := d.primary.entries[c.c>>(l-pb)]
+		if e.kind == entrySlow {
+			if d.slow == nil {
+				d.slow = make(map[Code]int)
+			}
+			if _, ok := d.slow[c]; ok {
+				return nil, ErrCorrupt
+			}
+			d.slow[c] = sym
+			continue
+		}
+		sub := d.secondary[e.sym]
+		rest := l - pb
+		start := (c.c & (1<<rest - 1)) << (sub.bits - rest)
+		if err := fillEntries(sub.entries[start:start+1<<(sub.bits-rest)], sym, l); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// fillEntries points entries at sym, coded with l bits. They must not be
+// taken by another code.
+func fillEntries(entries []decodeEntry, sym int, l uint) error {
+	for i := range entries {
+		if entries[i].kind != entryInvalid {
+			return ErrCorrupt
+		}
+		entries[i] = decodeEntry{sym: uint16(sym), bits: uint8(l), kind: entrySymbol}
+	}
+	return nil
+}
+
+// newConstantDecoder creates a decoder for a code consisting of a single
+// empty code for sym, which legacy streams use for single value tables.
+func newConstantDecoder(sym int) *huffmanDecoder {
+	e := decodeEntry{sym: uint16(sym), kind: entrySymbol}
+	return &huffmanDecoder{primary: decodeTable{bits: 1, entries: []decodeEntry{e, e}}}
+}
+
+// decode reads a single symbol.
+func (d *huffmanDecoder) decode(br *bitReader) (int, error) {
+	if br.nbits < 57 {
+		br.refill()
+	}
+	e := d.primary.entries[br.peek(d.primary.bits)]
+	if e.kind == entryLink {
+		sub := d.secondary[e.sym]
+		e = sub.entries[br.bits<<d.primary.bits>>(64-sub.bits)]
+	}
+	switch e.kind {
+	case entrySymbol:
+		if uint(e.bits) > br.nbits {
+			return 0, br.eofError()
+		}
+		br.consume(uint(e.bits))
+		return int(e.sym), nil
+	case entrySlow:
+		return d.decodeSlow(br)
+	}
+	if br.nbits == 0 {
+		return 0, br.eofError()
+	}
+	return 0, ErrCorrupt
+}
+
+// decodeSlow reads a code too long for the tables one bit at a time.
+func (d *huffmanDecoder) decodeSlow(br *bitReader) (int, error) {
+	match := Code{}
+	for match.bits < maxCodeLen {
+		b, err := br.readBool()
+		if err != nil {
+			return 0, err
+		}
+		match = addBit(match, b)
+		if sym, ok := d.slow[match]; ok {
+			return sym, nil
+		}
 	}
-	return a
+	return 0, ErrCorrupt
 }
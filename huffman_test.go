@@ -0,0 +1,380 @@
+package reductor
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/icza/bitio"
+)
+
+func Test_canonicalCodes(t *testing.T) {
+	var tests = []struct {
+		name    string
+		lengths []byte
+		want    codeTable
+	}{
+		{
+			name:    "Single symbol",
+			lengths: []byte{0, 1, 0},
+			want:    codeTable{{}, {c: 0b0, bits: 1}, {}},
+		},
+		{
+			name:    "Equal lengths",
+			lengths: []byte{2, 2, 2, 2},
+			want:    codeTable{{c: 0b00, bits: 2}, {c: 0b01, bits: 2}, {c: 0b10, bits: 2}, {c: 0b11, bits: 2}},
+		},
+		{
+			// Example from RFC 1951, section 3.2.2.
+			name:    "Mixed lengths",
+			lengths: []byte{3, 3, 3, 3, 3, 2, 4, 4},
+			want: codeTable{
+				{c: 0b010, bits: 3}, {c: 0b011, bits: 3}, {c: 0b100, bits: 3}, {c: 0b101, bits: 3},
+				{c: 0b110, bits: 3}, {c: 0b00, bits: 2}, {c: 0b1110, bits: 4}, {c: 0b1111, bits: 4},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := canonicalCodes(tt.lengths)
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("unexpected code for symbol %d (got %v want %v)", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_codeLengthsRoundtrip(t *testing.T) {
+	skewed := make([]byte, 256)
+	for i := range skewed {
+		skewed[i] = byte(1 + i%40)
+	}
+	var tests = []struct {
+		name    string
+		lengths []byte
+	}{
+		{name: "All equal", lengths: bytes.Repeat([]byte{8}, 256)},
+		{name: "Long zero runs", lengths: append(append(make([]byte, 200), 1, 1), make([]byte, 54)...)},
+		{name: "Short runs", lengths: []byte{0, 0, 3, 3, 3, 0, 5, 5, 5, 5, 5, 5, 5, 5, 5, 0, 0, 0, 64, 1}},
+		{name: "Varied lengths", lengths: skewed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			bw := newBinaryWriter(&buf)
+			bw.setCodeLengths(tt.lengths, nil)
+			if err := bw.writeCodeLengths(); err != nil {
+				t.Fatal(err)
+			}
+			bw.w.Close()
+			br := newBinaryReader(&buf)
+			got, err := br.readCodeLengths(len(tt.lengths))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.lengths) {
+				t.Errorf("unexpected lengths (got %v want %v)", got, tt.lengths)
+			}
+		})
+	}
+}
+
+func Test_CompactTable(t *testing.T) {
+	// All 256 byte values with the same frequency get 8 bit codes. The
+	// lengths take a few bytes, instead of 256 (value, length, code) triplets.
+	input := make([]byte, 256)
+	for i := range input {
+		input[i] = byte(i)
+	}
+	values := BytesToValues(input, DefaultMinMatch, DefaultMaxMatch, DefaultSearchSize)
+	litLen, dist := countFreqs(values)
+	bw := newBinaryWriter(ioutil.Discard)
+	bw.setCodeLengths(huffmanCodeLengths(litLen, DefaultMaxCodeLength), huffmanCodeLengths(dist, DefaultMaxCodeLength))
+	if err := bw.writeCodeLengths(); err != nil {
+		t.Fatal(err)
+	}
+	if bw.w.BitsCount > 32*8 {
+		t.Errorf("code lengths take %d bits", bw.w.BitsCount)
+	}
+}
+
+// kraftSum returns the sum of 2^(limit-l) over non-zero code lengths.
+func kraftSum(lengths []byte, limit int) uint64 {
+	var sum uint64
+	for _, l := range lengths {
+		if l != 0 {
+			sum += 1 << uint(limit-int(l))
+		}
+	}
+	return sum
+}
+
+func codeCost(freqs []int, lengths []byte) int {
+	var cost int
+	for sym, f := range freqs {
+		cost += f * int(lengths[sym])
+	}
+	return cost
+}
+
+func Test_huffmanCodeLengthsLimited(t *testing.T) {
+	// Fibonacci frequencies produce the deepest possible Huffman tree.
+	fibonacci := make([]int, 40)
+	fibonacci[0], fibonacci[1] = 1, 1
+	for i := 2; i < len(fibonacci); i++ {
+		fibonacci[i] = fibonacci[i-1] + fibonacci[i-2]
+	}
+	skewed := make([]int, 256)
+	for i := range skewed {
+		skewed[i] = 1 << uint(i%30)
+	}
+	var tests = []struct {
+		name  string
+		freqs []int
+		limit int
+	}{
+		{name: "Fibonacci limited to 15", freqs: fibonacci, limit: 15},
+		{name: "Fibonacci limited to 6", freqs: fibonacci, limit: 6},
+		{name: "Skewed limited to 8", freqs: skewed, limit: 8},
+		{name: "Skewed limited to 12", freqs: skewed, limit: 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unlimited := huffmanCodeLengths(tt.freqs, maxCodeLen)
+			got := huffmanCodeLengths(tt.freqs, tt.limit)
+			for sym, l := range got {
+				if int(l) > tt.limit {
+					t.Fatalf("code for symbol %d too long (got %d limit %d)", sym, l, tt.limit)
+				}
+				if (l == 0) != (tt.freqs[sym] == 0) {
+					t.Fatalf("unexpected length %d for symbol %d with frequency %d", l, sym, tt.freqs[sym])
+				}
+			}
+			if kraftSum(got, tt.limit) != 1<<uint(tt.limit) {
+				t.Errorf("code is not complete")
+			}
+			if codeCost(tt.freqs, got) < codeCost(tt.freqs, unlimited) {
+				t.Errorf("limited code cheaper than Huffman code")
+			}
+		})
+	}
+}
+
+func Test_packageMergeOptimal(t *testing.T) {
+	// Without an effective limit package-merge must match Huffman coding.
+	freqs := []int{5, 9, 12, 13, 16, 45, 0, 1, 1, 3}
+	huffman := huffmanCodeLengths(freqs, maxCodeLen)
+	pm := packageMerge(freqs, 20)
+	if codeCost(freqs, pm) != codeCost(freqs, huffman) {
+		t.Errorf("unexpected cost (got %d want %d)", codeCost(freqs, pm), codeCost(freqs, huffman))
+	}
+}
+
+func Test_CompressMaxCodeLength(t *testing.T) {
+	// Exponentially distributed bytes need long codes without a limit.
+	var skewed []byte
+	for i := 0; i < 20; i++ {
+		skewed = append(skewed, bytes.Repeat([]byte{byte(i)}, 1<<uint(i/2))...)
+		skewed = append(skewed, byte(i*7+100), byte(i*3+1))
+	}
+	// Every byte value plus matches use more symbols than bytes alone.
+	var all []byte
+	for i := 0; i < 256; i++ {
+		all = append(all, byte(i))
+	}
+	all = append(all, bytes.Repeat(all[:100], 3)...)
+	inputs := map[string][]byte{"skewed": skewed, "all bytes": all}
+	for name, input := range inputs {
+		for _, limit := range []uint8{MinCodeLengthLimit, DefaultMaxCodeLength, MaxCodeLengthLimit} {
+			var compressed, got bytes.Buffer
+			if err := Compress(&compressed, bytes.NewReader(input), Options{MaxCodeLength: limit}); err != nil {
+				t.Fatal(err)
+			}
+			if err := Decompress(&got, &compressed); err != nil {
+				t.Fatalf("%s, limit %d: %v", name, limit, err)
+			}
+			if !bytes.Equal(got.Bytes(), input) {
+				t.Errorf("%s, limit %d: roundtrip mismatch", name, limit)
+			}
+		}
+	}
+}
+
+// encodeSymbols writes codes of syms from table, padded to a full byte.
+func encodeSymbols(table codeTable, syms []int) []byte {
+	var buf bytes.Buffer
+	w := bitio.NewWriter(&buf)
+	for _, sym := range syms {
+		w.WriteBits(table[sym].c, table[sym].bits)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+// testSymbols returns n pseudo-random symbols with a non-empty code in table.
+func testSymbols(table codeTable, n int) []int {
+	var used []int
+	for sym, c := range table {
+		if c.bits != 0 {
+			used = append(used, sym)
+		}
+	}
+	rng := rand.New(rand.NewSource(1))
+	syms := make([]int, n)
+	for i := range syms {
+		syms[i] = used[rng.Intn(len(used))]
+	}
+	return syms
+}
+
+func Test_huffmanDecoder(t *testing.T) {
+	fibonacci := make([]int, 40)
+	fibonacci[0], fibonacci[1] = 1, 1
+	for i := 2; i < len(fibonacci); i++ {
+		fibonacci[i] = fibonacci[i-1] + fibonacci[i-2]
+	}
+	var tests = []struct {
+		name    string
+		lengths []byte
+	}{
+		{name: "Single symbol", lengths: []byte{0, 0, 1}},
+		{name: "Short codes", lengths: []byte{3, 3, 3, 3, 3, 2, 4, 4}},
+		{name: "Equal lengths", lengths: bytes.Repeat([]byte{8}, 256)},
+		// Codes up to primaryBits+secondaryBits use the secondary tables.
+		{name: "Secondary tables", lengths: huffmanCodeLengths(fibonacci[:20], maxCodeLen)},
+		// Longer codes are decoded bit by bit.
+		{name: "Slow codes", lengths: huffmanCodeLengths(fibonacci, maxCodeLen)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := canonicalCodes(tt.lengths)
+			syms := testSymbols(table, 1000)
+			data := encodeSymbols(table, syms)
+			dec, err := newHuffmanDecoder(table)
+			if err != nil {
+				t.Fatal(err)
+			}
+			br := newBitReader(bytes.NewReader(data))
+			for i, want := range syms {
+				got, err := dec.decode(br)
+				if err != nil {
+					t.Fatalf("symbol %d: %v", i, err)
+				}
+				if got != want {
+					t.Fatalf("unexpected symbol %d (got %d want %d)", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_huffmanDecoderTruncated(t *testing.T) {
+	// Symbol 1 has a 4 bit code. Only 2 of its bits fit in the first byte.
+	table := canonicalCodes([]byte{1, 4, 4, 3, 2})
+	data := encodeSymbols(table, []int{0, 0, 0, 0, 0, 0, 1})[:1]
+	dec, err := newHuffmanDecoder(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := newBitReader(bytes.NewReader(data))
+	for i := 0; i < 6; i++ {
+		if _, err := dec.decode(br); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dec.decode(br); err != io.EOF {
+		t.Errorf("unexpected error (got %v want %v)", err, io.EOF)
+	}
+}
+
+func Test_newHuffmanDecoderOverlapping(t *testing.T) {
+	var tests = []struct {
+		name  string
+		table codeTable
+	}{
+		{name: "Primary table", table: codeTable{{c: 0, bits: 1}, {c: 0, bits: 2}, {c: 3, bits: 2}}},
+		// The short code takes the entry linking to the secondary table.
+		{name: "Secondary table", table: codeTable{{c: 0, bits: primaryBits}, {c: 1, bits: primaryBits + 1}}},
+		{name: "Slow codes", table: codeTable{{c: 1, bits: maxCodeLen}, {c: 1, bits: maxCodeLen}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newHuffmanDecoder(tt.table); err != ErrCorrupt {
+				t.Errorf("unexpected error (got %v want %v)", err, ErrCorrupt)
+			}
+		})
+	}
+}
+
+// decodeBitByBit is the reference decoder, walking a code one bit at a
+// time and looking each prefix up in a map.
+func decodeBitByBit(r *bitio.Reader, valTable map[Code]int) (int, error) {
+	match := Code{}
+	for match.bits < maxCodeLen {
+		b, err := r.ReadBool()
+		if err != nil {
+			return 0, err
+		}
+		match = addBit(match, b)
+		if sym, ok := valTable[match]; ok {
+			return sym, nil
+		}
+	}
+	return 0, ErrCorrupt
+}
+
+// benchmarkCode returns a typical code: lengths for text-like frequencies.
+func benchmarkCode() codeTable {
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog, 0123456789 times! "), 10)
+	freqs := make([]int, 256)
+	for _, b := range input {
+		freqs[b]++
+	}
+	return canonicalCodes(huffmanCodeLengths(freqs, DefaultMaxCodeLength))
+}
+
+func Benchmark_decodeTable(b *testing.B) {
+	table := benchmarkCode()
+	syms := testSymbols(table, 1<<16)
+	data := encodeSymbols(table, syms)
+	dec, err := newHuffmanDecoder(table)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(syms)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br := newBitReader(bytes.NewReader(data))
+		for range syms {
+			if _, err := dec.decode(br); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func Benchmark_decodeBitByBit(b *testing.B) {
+	table := benchmarkCode()
+	syms := testSymbols(table, 1<<16)
+	data := encodeSymbols(table, syms)
+	valTable := make(map[Code]int)
+	for sym, c := range table {
+		if c.bits != 0 {
+			valTable[c] = sym
+		}
+	}
+	b.SetBytes(int64(len(syms)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := bitio.NewReader(bytes.NewReader(data))
+		for range syms {
+			if _, err := decodeBitByBit(r, valTable); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
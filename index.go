@@ -0,0 +1,140 @@
+package reductor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// A stream written with Options.Index ends with an index of its blocks of
+// input, which decompress independently, so that they can be decoded in
+// parallel or looked up by their offset:
+//
+//	count    uint32, the number of entries
+//	entries  count times indexEntrySize bytes:
+//	           offset        uint64, of the first block from the start of
+//	                         the stream
+//	           content       uint64, offset of the decoded data
+//	           size          uint32, of the blocks
+//	           content size  uint32, of the decoded data
+//	count    uint32, repeated, so that the index can be found from the end
+//	checksum uint32, CRC-32C of the index up to here
+//
+// The index follows the trailer and is present if flagIndex is set.
+const (
+	indexEntrySize  = 24
+	indexFooterSize = 8
+)
+
+// indexEntry describes blocks encoding a block of input.
+type indexEntry struct {
+	offset, content   int64
+	size, contentSize int
+}
+
+func (e indexEntry) marshal(b []byte) []byte {
+	var buf [indexEntrySize]byte
+	binary.BigEndian.PutUint64(buf[0:], uint64(e.offset))
+	binary.BigEndian.PutUint64(buf[8:], uint64(e.content))
+	binary.BigEndian.PutUint32(buf[16:], uint32(e.size))
+	binary.BigEndian.PutUint32(buf[20:], uint32(e.contentSize))
+	return append(b, buf[:]...)
+}
+
+func unmarshalIndexEntry(b []byte) indexEntry {
+	return indexEntry{
+		offset:      int64(binary.BigEndian.Uint64(b[0:])),
+		content:     int64(binary.BigEndian.Uint64(b[8:])),
+		size:        int(binary.BigEndian.Uint32(b[16:])),
+		contentSize: int(binary.BigEndian.Uint32(b[20:])),
+	}
+}
+
+// marshalIndex returns the index of entries.
+func marshalIndex(entries []indexEntry) []byte {
+	b := make([]byte, 4, 4+len(entries)*indexEntrySize+indexFooterSize)
+	binary.BigEndian.PutUint32(b, uint32(len(entries)))
+	for _, e := range entries {
+		b = e.marshal(b)
+	}
+	b = append(b, b[:4]...)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(b, castagnoli))
+	return append(b, sum[:]...)
+}
+
+// skipIndex reads an index from r, verifying only its checksum.
+func skipIndex(r *bitReader) error {
+	var count [4]byte
+	if err := r.readFull(count[:]); err != nil {
+		return noEOF(err)
+	}
+	crc := crc32.Update(0, castagnoli, count[:])
+	entry := make([]byte, indexEntrySize)
+	for i := binary.BigEndian.Uint32(count[:]); i > 0; i-- {
+		if err := r.readFull(entry); err != nil {
+			return noEOF(err)
+		}
+		crc = crc32.Update(crc, castagnoli, entry)
+	}
+	var footer [indexFooterSize]byte
+	if err := r.readFull(footer[:]); err != nil {
+		return noEOF(err)
+	}
+	crc = crc32.Update(crc, castagnoli, footer[:4])
+	if string(footer[:4]) != string(count[:]) || binary.BigEndian.Uint32(footer[4:]) != crc {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// readIndex reads the header and the index of a stream of size bytes held
+// by ra. It returns no entries if the stream has no index.
+func readIndex(ra io.ReaderAt, size int64) (header, []indexEntry, error) {
+	h, err := readHeader(bufio.NewReader(io.NewSectionReader(ra, 0, size)))
+	if err != nil {
+		return h, nil, err
+	}
+	if h.flags&flagIndex == 0 {
+		return h, nil, nil
+	}
+	var footer [indexFooterSize]byte
+	if size < indexFooterSize {
+		return h, nil, io.ErrUnexpectedEOF
+	}
+	if _, err := ra.ReadAt(footer[:], size-indexFooterSize); err != nil {
+		return h, nil, noEOF(err)
+	}
+	count := int64(binary.BigEndian.Uint32(footer[:]))
+	n := 4 + count*indexEntrySize + indexFooterSize
+	if n > size {
+		return h, nil, ErrCorrupt
+	}
+	b := make([]byte, n)
+	if _, err := ra.ReadAt(b, size-n); err != nil {
+		return h, nil, noEOF(err)
+	}
+	if string(b[:4]) != string(footer[:4]) || crc32.Checksum(b[:n-4], castagnoli) != binary.BigEndian.Uint32(footer[4:]) {
+		return h, nil, ErrCorrupt
+	}
+	// Entries must cover the content in order, with blocks between the
+	// header and the trailer.
+	entries := make([]indexEntry, count)
+	offset, content := int64(len(h.marshal())), int64(0)
+	for i := range entries {
+		e := unmarshalIndexEntry(b[4+i*indexEntrySize:])
+		if e.offset != offset || e.content != content || e.size <= 0 || e.contentSize <= 0 || e.contentSize > blockSize {
+			return h, nil, ErrCorrupt
+		}
+		offset, content = e.offset+int64(e.size), content+int64(e.contentSize)
+		entries[i] = e
+	}
+	if offset+4+int64(h.checksum().size())+n != size {
+		return h, nil, ErrCorrupt
+	}
+	if h.flags&flagContentSize != 0 && uint64(content) != h.contentSize {
+		return h, nil, ErrCorrupt
+	}
+	return h, entries, nil
+}
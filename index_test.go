@@ -0,0 +1,127 @@
+package reductor
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// indexedStream compresses input with an index, in blocks of blockSize.
+func indexedStream(t *testing.T, input []byte, blockSize int, checksum Checksum) []byte {
+	var compressed bytes.Buffer
+	zw, err := NewWriter(&compressed, Options{Concurrency: 2, Index: true, Checksum: checksum, ContentSize: int64(len(input))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.blockSize = blockSize
+	if _, err := zw.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return compressed.Bytes()
+}
+
+func Test_DecompressParallel(t *testing.T) {
+	text := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 1000)
+	var tests = []struct {
+		name     string
+		input    []byte
+		checksum Checksum
+		// blocks is the number of index entries.
+		blocks int
+	}{
+		{name: "Empty", input: []byte{}},
+		{name: "Single block", input: text[:5000], blocks: 1},
+		{name: "Many blocks", input: text, blocks: 7},
+		{name: "XXH64", input: text, checksum: ChecksumXXH64, blocks: 7},
+		{name: "No checksum", input: text, checksum: ChecksumNone, blocks: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed := indexedStream(t, tt.input, 7000, tt.checksum)
+			_, index, err := readIndex(bytes.NewReader(compressed), int64(len(compressed)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(index) != tt.blocks {
+				t.Errorf("unexpected number of index entries (got %d want %d)", len(index), tt.blocks)
+			}
+			for _, concurrency := range []int{1, 3} {
+				var got bytes.Buffer
+				if err := DecompressParallel(&got, bytes.NewReader(compressed), int64(len(compressed)), concurrency); err != nil {
+					t.Fatalf("concurrency %d: %v", concurrency, err)
+				}
+				if !bytes.Equal(got.Bytes(), tt.input) {
+					t.Errorf("concurrency %d: roundtrip mismatch (got %d bytes want %d bytes)", concurrency, got.Len(), len(tt.input))
+				}
+			}
+			// Sequential decompression verifies only the checksum of the
+			// index, so only the indexed path rejects a bogus entry.
+			stream := compressed[:len(compressed)-len(marshalIndex(index))]
+			bogus := append(append([]byte{}, stream...), marshalIndex(append(index, indexEntry{}))...)
+			if err := DecompressParallel(&bytes.Buffer{}, bytes.NewReader(bogus), int64(len(bogus)), 2); !errors.Is(err, ErrCorrupt) {
+				t.Errorf("bogus index entry: unexpected error (got %v want %v)", err, ErrCorrupt)
+			}
+			var got bytes.Buffer
+			if err := Decompress(&got, bytes.NewReader(bogus)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), tt.input) {
+				t.Errorf("roundtrip mismatch (got %d bytes want %d bytes)", got.Len(), len(tt.input))
+			}
+		})
+	}
+}
+
+func Test_DecompressParallelWithoutIndex(t *testing.T) {
+	input := bytes.Repeat([]byte("abcd"), 1000)
+	var compressed bytes.Buffer
+	if err := Compress(&compressed, bytes.NewReader(input), Options{}); err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := DecompressParallel(&got, bytes.NewReader(compressed.Bytes()), int64(compressed.Len()), 2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), input) {
+		t.Errorf("roundtrip mismatch (got %d bytes want %d bytes)", got.Len(), len(input))
+	}
+}
+
+func Test_DecompressParallelCorrupt(t *testing.T) {
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 1000)
+	compressed := indexedStream(t, input, 7000, ChecksumCRC32C)
+	_, index, err := readIndex(bytes.NewReader(compressed), int64(len(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := index[len(index)-1]
+	trailer := int(last.offset) + last.size + 4
+	var tests = []struct {
+		name string
+		// offset of the corrupted byte, from the end if negative.
+		offset int
+		want   error
+	}{
+		{name: "Index checksum", offset: -1, want: ErrCorrupt},
+		{name: "Index entry", offset: -12, want: ErrCorrupt},
+		{name: "Block", offset: int(index[1].offset) + index[1].size - 1, want: ErrChecksum},
+		{name: "Content checksum", offset: trailer, want: ErrChecksum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupted := append([]byte{}, compressed...)
+			offset := tt.offset
+			if offset < 0 {
+				offset += len(corrupted)
+			}
+			corrupted[offset] ^= 1
+			err := DecompressParallel(&bytes.Buffer{}, bytes.NewReader(corrupted), int64(len(corrupted)), 2)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("unexpected error (got %v want %v)", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,141 +1,546 @@
-package main
+package reductor
 
 import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"math/bits"
+	"sort"
 
 	"github.com/icza/bitio"
 )
 
-type BinaryWriter struct {
-	w         *bitio.Writer
-	codeTable CodeTable
-}
+// Code lengths of a block are run-length encoded with an alphabet similar
+// to DEFLATE's: symbols up to maxCodeLen are lengths, the rest are runs.
+// The encoded lengths are then Huffman coded themselves.
+const (
+	// clRepeat repeats the previous length 3-6 times (2 extra bits).
+	clRepeat = maxCodeLen + 1 + iota
+	// clZeros3 repeats length 0 3-10 times (3 extra bits).
+	clZeros3
+	// clZeros11 repeats length 0 11-138 times (7 extra bits).
+	clZeros11
+
+	clAlphabetSize
+	// Lengths of the code length code are written with clLenBits bits.
+	clLenBits = 4
+)
 
-func NewBinaryWriter(writer io.Writer, codeTable CodeTable) BinaryWriter {
-	w := bitio.NewWriter(writer)
-	return BinaryWriter{
-		w:         w,
-		codeTable: codeTable,
+// clOrder is the order in which lengths of the code length code are
+// written. Trailing zeros are omitted, so rarely used symbols go last.
+var clOrder = func() []int {
+	order := []int{clRepeat, clZeros3, clZeros11}
+	for l := 0; l <= maxCodeLen; l++ {
+		order = append(order, l)
 	}
+	return order
+}()
+
+// clToken is a code length alphabet symbol with its extra bits.
+type clToken struct {
+	sym       int
+	extra     uint64
+	extraBits byte
 }
 
-func (bw *BinaryWriter) Write(values []Value) {
-	var (
-		err  error
-		code uint64
-		l    byte
-	)
-	bw.writeTable()
-	for _, v := range values {
-		err = bw.w.WriteBool(v.IsLiteral)
-		if err != nil {
-			panic("write bool")
+// runLengthEncode converts code lengths to code length alphabet symbols.
+func runLengthEncode(lengths []byte) []clToken {
+	var tokens []clToken
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
 		}
-		if v.IsLiteral {
-			code, l = bw.getCodeForValue(v.GetLiteralBinary())
-			bw.w.WriteBits(code, l)
-		} else {
-			for _, b := range v.GetPointerBinary() {
-				code, l = bw.getCodeForValue(b)
-				bw.w.WriteBits(code, l)
+		switch {
+		case l == 0 && run >= 11:
+			run = min(run, 138)
+			tokens = append(tokens, clToken{clZeros11, uint64(run - 11), 7})
+		case l == 0 && run >= 3:
+			tokens = append(tokens, clToken{clZeros3, uint64(run - 3), 3})
+		case l != 0 && run >= 4:
+			tokens = append(tokens, clToken{sym: int(l)})
+			// Repeat as much as possible, the rest is left for the next round.
+			repeated := 0
+			for run-1-repeated >= 3 {
+				r := min(run-1-repeated, 6)
+				tokens = append(tokens, clToken{clRepeat, uint64(r - 3), 2})
+				repeated += r
 			}
+			run = 1 + repeated
+		default:
+			tokens = append(tokens, clToken{sym: int(l)})
+			run = 1
 		}
+		i += run
 	}
-	bw.w.Close()
+	return tokens
 }
-func (bw *BinaryWriter) writeTable() {
-	// First 8 bits denote amount of elements in the table.
-	if len(bw.codeTable) == 0 {
-		panic("code table of length 0")
+
+type binaryWriter struct {
+	w *bitio.CountWriter
+	// lengths holds code lengths of both alphabets, literal/length first.
+	lengths []byte
+	litLen  codeTable
+	dist    codeTable
+}
+
+func newBinaryWriter(writer io.Writer) binaryWriter {
+	w := bitio.NewCountWriter(writer)
+	return binaryWriter{
+		w: w,
 	}
-	if err := bw.w.WriteBits(uint64(len(bw.codeTable)-1), 8); err != nil {
-		panic(err)
+}
+
+// setCodeLengths selects the codes used for the following blocks.
+func (bw *binaryWriter) setCodeLengths(litLen, dist []byte) {
+	bw.lengths = append(append(bw.lengths[:0], litLen...), dist...)
+	bw.litLen = canonicalCodes(litLen)
+	bw.dist = canonicalCodes(dist)
+}
+
+// reuseFlag marks the count of a block coded with the codes of the
+// previous coded block, whose code lengths are omitted.
+const reuseFlag = 1 << 30
+
+// writeBlock writes values preceded by their count and the code lengths,
+// unless reuse is set and the codes were written with the previous block.
+// The block is padded to a full byte. values must not be empty, as an
+// empty block marks the end of the stream, see writeEnd.
+func (bw *binaryWriter) writeBlock(values []Value, reuse bool) error {
+	n := uint32(len(values))
+	if reuse {
+		n |= reuseFlag
 	}
-	for k, v := range bw.codeTable {
-		// Next, we write (byte, byte, code) triplets.
-		// First byte denotes a value to be encoded/decoded.
-		if err := bw.w.WriteBits(uint64(k), 8); err != nil {
-			panic(err)
-		}
-		// Second byte denotes size of the code.
-		if err := bw.w.WriteBits(uint64(v.bits), 8); err != nil {
-			panic(err)
+	var count [4]byte
+	binary.BigEndian.PutUint32(count[:], n)
+	bw.w.TryWrite(count[:])
+	if !reuse {
+		if err := bw.writeCodeLengths(); err != nil {
+			return err
 		}
-		// After that, code is written.
-		if err := bw.w.WriteBits(uint64(v.c), v.bits); err != nil {
-			panic(err)
+	}
+	bw.writeValues(values)
+	if bw.w.TryError != nil {
+		return bw.w.TryError
+	}
+	_, err := bw.w.Align()
+	return err
+}
+
+func (bw *binaryWriter) writeValues(values []Value) {
+	reps := newRepHistory()
+	for _, v := range values {
+		if v.IsLiteral {
+			c := bw.litLen[v.val]
+			bw.w.TryWriteBits(c.c, c.bits)
+			continue
 		}
+		sym, extra, extraBits := bucket(uint32(v.length), lengthMantissa)
+		c := bw.litLen[256+sym]
+		bw.w.TryWriteBits(c.c, c.bits)
+		bw.w.TryWriteBits(uint64(extra), uint8(extraBits))
+		sym, extra, extraBits = distanceSymbol(v.distance, &reps)
+		reps.update(v.distance)
+		c = bw.dist[sym]
+		bw.w.TryWriteBits(c.c, c.bits)
+		bw.w.TryWriteBits(uint64(extra), uint8(extraBits))
 	}
 }
 
-func (bw *BinaryWriter) getCodeForValue(val byte) (uint64, byte) {
-	code := bw.codeTable[val]
-	return uint64(code.c), code.bits
+// storedFlag marks the count of a stored block, which holds raw bytes
+// instead of values. The rest of the count is the number of bytes.
+const storedFlag = 1 << 31
+
+// storedHeaderSize is the size of a stored block, apart from its bytes.
+const storedHeaderSize = 4
+
+// writeStored writes a stored block holding raw, which must not be empty.
+func (bw *binaryWriter) writeStored(raw []byte) error {
+	var count [storedHeaderSize]byte
+	binary.BigEndian.PutUint32(count[:], storedFlag|uint32(len(raw)))
+	bw.w.TryWrite(count[:])
+	bw.w.TryWrite(raw)
+	return bw.w.TryError
 }
 
-type BinaryReader struct {
-	r        *bitio.Reader
-	valTable map[Code]byte
+// writeEnd writes the empty block that ends the stream.
+func (bw *binaryWriter) writeEnd() error {
+	_, err := bw.w.Write(make([]byte, 4))
+	return err
 }
 
-func NewBinaryReader(reader io.Reader) BinaryReader {
-	r := bitio.NewReader(reader)
-	return BinaryReader{
-		r: r,
+// writeCodeLengths writes run-length encoded code lengths, preceded by the
+// code they are encoded with:
+//
+//	count   7 bits, number of code length code lengths that follow
+//	lengths clLenBits each, in clOrder
+//	tokens  code length alphabet symbols, each followed by its extra bits
+func (bw *binaryWriter) writeCodeLengths() error {
+	tokens := runLengthEncode(bw.lengths)
+	freqs := make([]int, clAlphabetSize)
+	for _, t := range tokens {
+		freqs[t.sym]++
+	}
+	clLengths := huffmanCodeLengths(freqs, 1<<clLenBits-1)
+	clCodes := canonicalCodes(clLengths)
+
+	n := len(clOrder)
+	for n > 0 && clLengths[clOrder[n-1]] == 0 {
+		n--
 	}
+	bw.w.TryWriteBits(uint64(n), 7)
+	for _, sym := range clOrder[:n] {
+		bw.w.TryWriteBits(uint64(clLengths[sym]), clLenBits)
+	}
+	for _, t := range tokens {
+		c := clCodes[t.sym]
+		bw.w.TryWriteBits(c.c, c.bits)
+		bw.w.TryWriteBits(t.extra, t.extraBits)
+	}
+	return bw.w.TryError
 }
 
-func (br *BinaryReader) Read() []Value {
-	br.valTable = br.readTable()
-	values := make([]Value, 0)
-	for {
-		val, err := br.consumeValue()
+type binaryReader struct {
+	r *bitReader
+	// litLenSize and distSize are the sizes of alphabets and numReps is
+	// the number of repeat symbols, which depend on the format version.
+	litLenSize, distSize, numReps int
+	// version is the format version, which decides the kinds of blocks.
+	version byte
+	litLen  *huffmanDecoder
+	dist    *huffmanDecoder
+	reps    repHistory
+	// legacy decodes all bytes of headerless streams.
+	legacy *huffmanDecoder
+}
+
+func newBinaryReader(reader io.Reader) binaryReader {
+	return binaryReader{
+		r:          newBitReader(reader),
+		litLenSize: litLenSize,
+		distSize:   distSize,
+		numReps:    numReps,
+		version:    formatVersion,
+	}
+}
+
+// readBlock reads a single block: the number of values, the code lengths,
+// unless the block reuses the previous ones, and the values themselves,
+// padded to a full byte. Stored blocks are returned as raw bytes instead
+// of values. It returns io.EOF when it reads the empty block that ends
+// the stream.
+func (br *binaryReader) readBlock() ([]Value, []byte, error) {
+	var count [4]byte
+	if err := br.r.readFull(count[:]); err != nil {
+		return nil, nil, noEOF(err)
+	}
+	n := binary.BigEndian.Uint32(count[:])
+	if n == 0 {
+		return nil, nil, io.EOF
+	}
+	if br.version >= 2 && n&storedFlag != 0 {
+		raw, err := br.readStored(int(n &^ storedFlag))
+		return nil, raw, err
+	}
+	reuse := br.version >= 2 && n&reuseFlag != 0
+	if reuse {
+		n &^= reuseFlag
+	}
+	// Like stored blocks, coded blocks never hold more than a block of
+	// input, which takes at most one value per byte.
+	if n > blockSize {
+		return nil, nil, ErrCorrupt
+	}
+	if reuse {
+		if br.litLen == nil || n == 0 {
+			return nil, nil, ErrCorrupt
+		}
+	} else if err := br.readCodes(); err != nil {
+		return nil, nil, err
+	}
+	values := make([]Value, 0, n)
+	br.reps = newRepHistory()
+	for i := uint32(0); i < n; i++ {
+		val, err := br.readValue()
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			panic(err)
+			return nil, nil, noEOF(err)
 		}
 		values = append(values, val)
 	}
-	return values
+	// The block is padded with zero bits. They are never decoded, but
+	// checking them catches some corruption the count alone would not.
+	pad := uint(-br.r.count & 7)
+	if padding, err := br.r.readBits(pad); err != nil {
+		return nil, nil, noEOF(err)
+	} else if padding != 0 {
+		return nil, nil, ErrCorrupt
+	}
+	return values, nil, nil
+}
+
+// readCodes reads the code lengths of a block and sets up its decoders.
+func (br *binaryReader) readCodes() error {
+	lengths, err := br.readCodeLengths(br.litLenSize + br.distSize)
+	if err != nil {
+		return err
+	}
+	if br.litLen, err = newCanonicalDecoder(lengths[:br.litLenSize]); err != nil {
+		return err
+	}
+	br.dist, err = newCanonicalDecoder(lengths[br.litLenSize:])
+	return err
+}
+
+// readStored reads the n bytes of a stored block. Writer never stores
+// more than a block of input, so longer blocks are rejected before
+// anything is allocated for them.
+func (br *binaryReader) readStored(n int) ([]byte, error) {
+	if n == 0 || n > blockSize {
+		return nil, ErrCorrupt
+	}
+	raw := make([]byte, n)
+	if err := br.r.readFull(raw); err != nil {
+		return nil, noEOF(err)
+	}
+	return raw, nil
+}
+
+// readCodeLengths reads lengths of a code for an alphabet of n symbols,
+// as written by binaryWriter.writeCodeLengths.
+func (br *binaryReader) readCodeLengths(n int) ([]byte, error) {
+	k, err := br.r.readBits(7)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	if int(k) > len(clOrder) {
+		return nil, ErrCorrupt
+	}
+	clLengths := make([]byte, clAlphabetSize)
+	for _, sym := range clOrder[:k] {
+		l, err := br.r.readBits(clLenBits)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		clLengths[sym] = byte(l)
+	}
+	clDec, err := newCanonicalDecoder(clLengths)
+	if err != nil {
+		return nil, err
+	}
+	lengths := make([]byte, n)
+	for i := 0; i < n; {
+		sym, err := clDec.decode(br.r)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		var (
+			l     byte
+			run   = 1
+			extra uint64
+		)
+		switch sym {
+		case clRepeat:
+			if i == 0 {
+				return nil, ErrCorrupt
+			}
+			l = lengths[i-1]
+			extra, err = br.r.readBits(2)
+			run = 3 + int(extra)
+		case clZeros3:
+			extra, err = br.r.readBits(3)
+			run = 3 + int(extra)
+		case clZeros11:
+			extra, err = br.r.readBits(7)
+			run = 11 + int(extra)
+		default:
+			l = byte(sym)
+		}
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		if i+run > n {
+			return nil, ErrCorrupt
+		}
+		for ; run > 0; run-- {
+			lengths[i] = l
+			i++
+		}
+	}
+	return lengths, nil
 }
 
-func (br *BinaryReader) readTable() map[Code]byte {
-	valTable := make(map[Code]byte)
+// newCanonicalDecoder creates a decoder for canonical codes with lengths.
+func newCanonicalDecoder(lengths []byte) (*huffmanDecoder, error) {
+	table := canonicalCodes(lengths)
+	var codes []Code
+	for _, c := range table {
+		if c.bits != 0 {
+			codes = append(codes, c)
+		}
+	}
+	// A lone symbol is coded with a single bit.
+	lone := len(codes) == 1 && codes[0].bits == 1
+	if !lone && !isCompleteCode(codes) {
+		return nil, ErrCorrupt
+	}
+	return newHuffmanDecoder(table)
+}
+
+// readLegacyTable reads the table of a headerless stream. It consists of
+// the number of entries minus one, followed by (value, code length, code)
+// triplets.
+func (br *binaryReader) readLegacyTable() (*huffmanDecoder, error) {
+	table := make(codeTable, 256)
+	var (
+		seen  [256]bool
+		codes []Code
+	)
 	// First 8 bits denote amount of elements in the table.
-	size, err := br.r.ReadBits(8)
+	size, err := br.r.readBits(8)
 	if err != nil {
-		panic(err)
+		return nil, noEOF(err)
 	}
 	// We substracted 1 when writing to avoid overflowing byte.
 	size += 1
 	var i uint64
 	for i = 0; i < size; i++ {
 		// Value.
-		val, err := br.r.ReadBits(8)
+		val, err := br.r.readBits(8)
 		if err != nil {
-			panic(err)
+			return nil, noEOF(err)
 		}
 		// Code size.
-		codeBits, err := br.r.ReadBits(8)
+		codeBits, err := br.r.readBits(8)
 		if err != nil {
-			panic(err)
+			return nil, noEOF(err)
+		}
+		if codeBits > maxCodeLen {
+			return nil, ErrCorrupt
 		}
 		// Code itself.
-		code, err := br.r.ReadBits(byte(codeBits))
+		code, err := br.r.readBits(uint(codeBits))
 		if err != nil {
-			panic(err)
+			return nil, noEOF(err)
+		}
+		// Every value has a single code.
+		if seen[val] {
+			return nil, ErrCorrupt
+		}
+		seen[val] = true
+		table[val] = Code{c: code, bits: byte(codeBits)}
+		codes = append(codes, table[val])
+	}
+	if !isCompleteCode(codes) || !isPrefixCode(codes) {
+		return nil, ErrCorrupt
+	}
+	// An empty code is complete only on its own.
+	if codes[0].bits == 0 {
+		for sym := range table {
+			if seen[sym] {
+				return newConstantDecoder(sym), nil
+			}
+		}
+	}
+	return newHuffmanDecoder(table)
+}
+
+// isCompleteCode reports whether code lengths in codes satisfy Kraft's
+// equality, which holds for every Huffman tree.
+func isCompleteCode(codes []Code) bool {
+	// The sum of 2^(64-bits) over all codes must be exactly 2^64.
+	var hi, lo, carry uint64
+	for _, c := range codes {
+		if c.bits == 0 {
+			hi++
+			continue
+		}
+		lo, carry = bits.Add64(lo, 1<<(64-c.bits), 0)
+		hi += carry
+	}
+	return hi == 1 && lo == 0
+}
+
+// isPrefixCode reports whether no code in codes is a prefix of another,
+// including itself when it appears twice.
+func isPrefixCode(codes []Code) bool {
+	// A code covers the 64-bit values starting with it. Sorted by their
+	// first value, each code must end before the next one starts.
+	sorted := append([]Code(nil), codes...)
+	sort.Slice(sorted, func(i, j int) bool { return codeStart(sorted[i]) < codeStart(sorted[j]) })
+	for i := 1; i < len(sorted); i++ {
+		prev := sorted[i-1]
+		if prev.bits == 0 || codeStart(sorted[i])-codeStart(prev) < 1<<(64-prev.bits) {
+			return false
 		}
-		valTable[Code{c: code, bits: byte(codeBits)}] = byte(val)
 	}
-	return valTable
+	return true
+}
+
+// codeStart returns the smallest 64-bit value starting with c.
+func codeStart(c Code) uint64 {
+	return c.c << (64 - c.bits)
 }
 
-func (br *BinaryReader) consumeValue() (Value, error) {
-	isLiteral, err := br.r.ReadBool()
+// readLegacyValues reads up to n values of a headerless stream, which
+// carries a single table followed by values until the end of input.
+// Padding bits at the end of such a stream may decode as extra values.
+func (br *binaryReader) readLegacyValues(n int) ([]Value, error) {
+	values := make([]Value, 0, n)
+	for len(values) < n {
+		val, err := br.consumeValue()
+		if errors.Is(err, io.EOF) {
+			if len(values) == 0 {
+				return nil, io.EOF
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, val)
+	}
+	return values, nil
+}
+
+// readValue reads a literal, or a pointer as a length and a distance.
+func (br *binaryReader) readValue() (Value, error) {
+	sym, err := br.litLen.decode(br.r)
+	if err != nil {
+		return Value{}, err
+	}
+	if sym < 256 {
+		return NewValue(true, byte(sym), 0, 0), nil
+	}
+	length, err := br.readBucket(sym-256, lengthMantissa)
+	if err != nil {
+		return Value{}, err
+	}
+	sym, err = br.dist.decode(br.r)
+	if err != nil {
+		return Value{}, err
+	}
+	var distance uint32
+	if sym < br.numReps {
+		distance = br.reps[sym]
+	} else if distance, err = br.readBucket(sym-br.numReps, distanceMantissa); err != nil {
+		return Value{}, err
+	}
+	br.reps.update(distance)
+	return NewValue(false, 0, uint16(length), distance), nil
+}
+
+// readBucket reads the extra bits of bucket sym and returns its value.
+func (br *binaryReader) readBucket(sym int, k uint) (uint32, error) {
+	base, extraBits := bucketBase(sym, k)
+	extra, err := br.r.readBits(extraBits)
+	return base + uint32(extra), err
+}
+
+// consumeValue reads a value of a headerless stream: a literal flag
+// followed by a byte, or by the three bytes of GetPointerBinary.
+func (br *binaryReader) consumeValue() (Value, error) {
+	isLiteral, err := br.r.readBool()
 	if err != nil {
 		return Value{}, err
 	}
@@ -153,21 +558,12 @@ func (br *BinaryReader) consumeValue() (Value, error) {
 	return pointerMatchesToPointer(matches), nil
 }
 
-func (br *BinaryReader) readMatch() (byte, error) {
-	match := Code{}
-	for {
-		b, err := br.r.ReadBool()
-		if err != nil {
-			return 0, err
-		}
-		match = addBit(match, b)
-		if val, ok := br.valTable[match]; ok {
-			return val, nil
-		}
-	}
+func (br *binaryReader) readMatch() (byte, error) {
+	sym, err := br.legacy.decode(br.r)
+	return byte(sym), err
 }
 
-func (br *BinaryReader) readPointerMatches() ([]byte, error) {
+func (br *binaryReader) readPointerMatches() ([]byte, error) {
 	var err error
 	bytes := make([]byte, 3)
 	for i := range bytes {
@@ -184,5 +580,14 @@ func pointerMatchesToPointer(bytes []byte) Value {
 	distance := binary.BigEndian.Uint16(bytes)
 	// The last byte is length.
 	length := bytes[2]
-	return NewValue(false, 0, length, distance)
+	return NewValue(false, 0, uint16(length), uint32(distance))
+}
+
+// noEOF converts io.EOF into io.ErrUnexpectedEOF. It is used where the
+// stream must not end.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
 }
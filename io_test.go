@@ -0,0 +1,95 @@
+package reductor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// blockPadding returns the number of padding bits at the end of the block
+// encoding input with default options.
+func blockPadding(input []byte) int {
+	opts := Options{}.withDefaults()
+	values := BytesToValues(input, opts.MinMatch, opts.MaxMatch, opts.SearchSize)
+	litLen, dist := countFreqs(values)
+	bw := newBinaryWriter(ioutil.Discard)
+	bw.setCodeLengths(huffmanCodeLengths(litLen, DefaultMaxCodeLength), huffmanCodeLengths(dist, DefaultMaxCodeLength))
+	if err := bw.writeCodeLengths(); err != nil {
+		panic(err)
+	}
+	bw.writeValues(values)
+	// The 4 byte count preceding the code lengths does not change padding.
+	return int(-bw.w.BitsCount & 7)
+}
+
+// firstBlockStored reports whether the first block of compressed is stored.
+func firstBlockStored(compressed []byte) bool {
+	r := bytes.NewReader(compressed)
+	if _, err := readHeader(r); err != nil {
+		panic(err)
+	}
+	var count [4]byte
+	if _, err := io.ReadFull(r, count[:]); err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint32(count[:])&storedFlag != 0
+}
+
+func Test_RoundtripEveryPadding(t *testing.T) {
+	text := []byte("If the padding happens to form a flag bit plus a valid short code, spurious bytes get appended.")
+	var inputs [][]byte
+	// Repeating the text keeps inputs compressible, so they are not stored.
+	for n := 0; n <= len(text); n++ {
+		inputs = append(inputs, bytes.Repeat(text[:n], 3))
+	}
+	// Single value tables have empty codes, so only flag bits are written.
+	for n := 1; n <= 16; n++ {
+		inputs = append(inputs, bytes.Repeat([]byte("a"), n))
+	}
+
+	covered := make(map[int]bool)
+	for _, input := range inputs {
+		var compressed, got bytes.Buffer
+		if err := Compress(&compressed, bytes.NewReader(input), Options{Checksum: ChecksumNone}); err != nil {
+			t.Fatal(err)
+		}
+		if len(input) > 0 && !firstBlockStored(compressed.Bytes()) {
+			covered[blockPadding(input)] = true
+		}
+		if err := Decompress(&got, &compressed); err != nil {
+			t.Fatalf("input %q: %v", input, err)
+		}
+		if !bytes.Equal(got.Bytes(), input) {
+			t.Errorf("unexpected output (got %q want %q)", got.Bytes(), input)
+		}
+	}
+	for pad := 0; pad < 8; pad++ {
+		if !covered[pad] {
+			t.Errorf("no input ends with %d padding bits", pad)
+		}
+	}
+}
+
+func Test_NonZeroPaddingRejected(t *testing.T) {
+	input := []byte("abcabcabcabc")
+	if blockPadding(input) == 0 {
+		t.Fatal("input must produce padding")
+	}
+	var compressed bytes.Buffer
+	if err := Compress(&compressed, bytes.NewReader(input), Options{Checksum: ChecksumNone}); err != nil {
+		t.Fatal(err)
+	}
+	if firstBlockStored(compressed.Bytes()) {
+		t.Fatal("input must not be stored")
+	}
+	// The last byte of the block precedes the 4 byte end marker.
+	corrupted := compressed.Bytes()
+	corrupted[len(corrupted)-5] |= 1
+	err := Decompress(&bytes.Buffer{}, bytes.NewReader(corrupted))
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("unexpected error (got %v want %v)", err, ErrCorrupt)
+	}
+}
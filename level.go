@@ -0,0 +1,51 @@
+package reductor
+
+// Compression levels trade speed for ratio by choosing the match finder,
+// the parser, the search window and the chain depth together. Level 1 is
+// the fastest, level 9 compresses best, and LevelUltra goes further at
+// the cost of memory: both Writer and Reader keep up to 32 MiB of it.
+const (
+	MinLevel   = 1
+	MaxLevel   = 9
+	LevelUltra = 10
+)
+
+// levels holds the presets of compression levels, indexed by level. Zero
+// fields are left to the defaults.
+var levels = [...]Options{
+	1:          {MatchFinder: MatchFinderHashChain, Parser: ParserGreedy, SearchSize: 1 << 16, ChainDepth: 2},
+	2:          {MatchFinder: MatchFinderHashChain, Parser: ParserGreedy, SearchSize: 1 << 16, ChainDepth: 4},
+	3:          {MatchFinder: MatchFinderHashChain, Parser: ParserGreedy, SearchSize: 1 << 16, ChainDepth: 8},
+	4:          {MatchFinder: MatchFinderHashChain, Parser: ParserLazy, SearchSize: 1 << 16, ChainDepth: 8},
+	5:          {MatchFinder: MatchFinderHashChain, Parser: ParserLazy, SearchSize: 1 << 17, ChainDepth: 16},
+	6:          {MatchFinder: MatchFinderHashChain, Parser: ParserLazy, SearchSize: 1 << 18, ChainDepth: 32},
+	7:          {MatchFinder: MatchFinderHashChain, Parser: ParserLazy2, SearchSize: 1 << 19, ChainDepth: 64, MaxMatch: 1024},
+	8:          {MatchFinder: MatchFinderBinaryTree, Parser: ParserLazy2, SearchSize: 1 << 20, ChainDepth: 32, MaxMatch: 1024},
+	9:          {MatchFinder: MatchFinderBinaryTree, Parser: ParserOptimal, SearchSize: 1 << 22, ChainDepth: 32, MaxMatch: 4096},
+	LevelUltra: {MatchFinder: MatchFinderBinaryTree, Parser: ParserOptimal, SearchSize: 1 << 24, ChainDepth: 128, MaxMatch: 65535},
+}
+
+// withLevel returns a copy of opts with fields left unset filled in from
+// the preset of opts.Level.
+func (opts Options) withLevel() Options {
+	if opts.Level < MinLevel || opts.Level > LevelUltra {
+		return opts
+	}
+	preset := levels[opts.Level]
+	if opts.MatchFinder == MatchFinderDefault {
+		opts.MatchFinder = preset.MatchFinder
+	}
+	if opts.Parser == ParserDefault {
+		opts.Parser = preset.Parser
+	}
+	if opts.MaxMatch == 0 {
+		opts.MaxMatch = preset.MaxMatch
+	}
+	if opts.SearchSize == 0 {
+		opts.SearchSize = preset.SearchSize
+	}
+	if opts.ChainDepth == 0 {
+		opts.ChainDepth = preset.ChainDepth
+	}
+	return opts
+}
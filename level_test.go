@@ -0,0 +1,72 @@
+package reductor
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func Test_CompressLevels(t *testing.T) {
+	input := benchmarkText(1 << 18)
+	sizes := make(map[int]int)
+	for level := MinLevel; level <= LevelUltra; level++ {
+		t.Run(strconv.Itoa(level), func(t *testing.T) {
+			var compressed bytes.Buffer
+			if err := Compress(&compressed, bytes.NewReader(input), Options{Level: level}); err != nil {
+				t.Fatal(err)
+			}
+			sizes[level] = compressed.Len()
+			var got bytes.Buffer
+			if err := Decompress(&got, &compressed); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), input) {
+				t.Errorf("roundtrip mismatch (got %d bytes want %d bytes)", got.Len(), len(input))
+			}
+		})
+	}
+	if sizes[1] <= sizes[5] || sizes[5] <= sizes[MaxLevel] {
+		t.Errorf("sizes do not decrease with level (got %v)", sizes)
+	}
+}
+
+func Test_OptionsWithLevel(t *testing.T) {
+	var tests = []struct {
+		name string
+		opts Options
+		want Options
+	}{
+		{
+			name: "No level",
+			opts: Options{},
+			want: Options{MatchFinder: MatchFinderHashChain, Parser: ParserLazy, MaxMatch: DefaultMaxMatch, SearchSize: DefaultSearchSize, ChainDepth: DefaultChainDepth},
+		},
+		{
+			name: "Preset",
+			opts: Options{Level: 9},
+			want: Options{MatchFinder: MatchFinderBinaryTree, Parser: ParserOptimal, MaxMatch: 4096, SearchSize: 1 << 22, ChainDepth: 32},
+		},
+		{
+			name: "Preset with defaults",
+			opts: Options{Level: 1},
+			want: Options{MatchFinder: MatchFinderHashChain, Parser: ParserGreedy, MaxMatch: DefaultMaxMatch, SearchSize: 1 << 16, ChainDepth: 2},
+		},
+		{
+			name: "Explicit fields override the preset",
+			opts: Options{Level: 9, MatchFinder: MatchFinderHashChain, Parser: ParserLazy, SearchSize: 1000},
+			want: Options{MatchFinder: MatchFinderHashChain, Parser: ParserLazy, MaxMatch: 4096, SearchSize: 1000, ChainDepth: 32},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.opts.withDefaults()
+			if got.MatchFinder != tt.want.MatchFinder || got.Parser != tt.want.Parser {
+				t.Errorf("unexpected algorithms (got %v and %v want %v and %v)", got.MatchFinder, got.Parser, tt.want.MatchFinder, tt.want.Parser)
+			}
+			if got.MaxMatch != tt.want.MaxMatch || got.SearchSize != tt.want.SearchSize || got.ChainDepth != tt.want.ChainDepth {
+				t.Errorf("unexpected sizes (got max-match %d, search size %d, chain depth %d want %d, %d, %d)",
+					got.MaxMatch, got.SearchSize, got.ChainDepth, tt.want.MaxMatch, tt.want.SearchSize, tt.want.ChainDepth)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package reductor
+
+import "math/bits"
+
+// Long-distance matching finds repeats too far apart for the regular match
+// finders, like zstd's --long. Windows of longMinMatch bytes are hashed
+// with a rolling gear hash and about one in 2^longRateBits of them, chosen
+// by their content, is remembered in a table. The same windows are chosen
+// in every copy of the data, so copies find each other through the table.
+const (
+	// longMinMatch is the length of fingerprinted windows and the shortest
+	// long-distance match.
+	longMinMatch = 64
+	// longRateBits selects the share of fingerprinted windows.
+	longRateBits = 5
+)
+
+// gearTable maps bytes to random values mixed into the gear hash.
+var gearTable = func() [256]uint64 {
+	var t [256]uint64
+	// SplitMix64, so that the table is the same everywhere.
+	x := uint64(0)
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		t[i] = z ^ z>>31
+	}
+	return t
+}()
+
+// longMatch is a match found by longMatcher, starting at pos.
+type longMatch struct {
+	pos, length, distance int
+}
+
+// longMatcher finds long-distance matches. Like match finders, it is kept
+// for the whole stream and positions are indexes of its input.
+type longMatcher struct {
+	window int
+	// table holds a fingerprinted position, plus one, for every hash.
+	table     []uint32
+	tableBits uint
+}
+
+func newLongMatcher(window int) *longMatcher {
+	// There is about one fingerprint per 2^longRateBits bytes of window.
+	tableBits := uint(max(8, bits.Len(uint(window))-longRateBits))
+	return &longMatcher{
+		window:    window,
+		table:     make([]uint32, 1<<tableBits),
+		tableBits: tableBits,
+	}
+}
+
+// slide drops the first n bytes of input, shifting all positions.
+func (lm *longMatcher) slide(n int) {
+	for i, p := range lm.table {
+		if int(p) > n {
+			lm.table[i] = p - uint32(n)
+		} else {
+			lm.table[i] = 0
+		}
+	}
+}
+
+// find returns matches for input[start:], in order and not overlapping.
+// input[:start] is history, which was passed to find before. Matches may
+// overlap their source, when the input repeats with a shorter period.
+func (lm *longMatcher) find(input []byte, start int) []longMatch {
+	var (
+		matches []longMatch
+		h       uint64
+		// anchor is the end of the last match.
+		anchor = start
+	)
+	// Windows overlapping the end of the history were not fingerprinted
+	// yet, the hash is primed with their first bytes.
+	from := max(0, start-longMinMatch+1)
+	for p := from; p < len(input); p++ {
+		h = h<<1 + gearTable[input[p]]
+		// The hash depends only on the window input[s:p+1].
+		s := p - longMinMatch + 1
+		if s < from || h>>(64-longRateBits) != 0 {
+			continue
+		}
+		slot := &lm.table[h>>(64-longRateBits-lm.tableBits)&(1<<lm.tableBits-1)]
+		cand := int(*slot) - 1
+		*slot = uint32(s + 1)
+		if cand < 0 || p < anchor || s-cand > lm.window {
+			continue
+		}
+		length := getMatchLen(input[cand:], input[s:])
+		if length < longMinMatch {
+			continue
+		}
+		// Extend the match back, up to the last one.
+		pos, distance := s, s-cand
+		for pos > anchor && pos > distance && input[pos-1] == input[pos-1-distance] {
+			pos, length = pos-1, length+1
+		}
+		if pos < anchor {
+			// The window started in data which is already encoded.
+			pos, length = anchor, length-(anchor-pos)
+			if length < longMinMatch {
+				continue
+			}
+		}
+		matches = append(matches, longMatch{pos: pos, length: length, distance: distance})
+		anchor = pos + length
+	}
+	return matches
+}
+
+// clipLongMatches shortens matches copying input before start, dropping
+// those which are no longer long enough.
+func clipLongMatches(matches []longMatch, start int) []longMatch {
+	clipped := matches[:0]
+	for _, m := range matches {
+		if src := m.pos - m.distance; src < start {
+			m.pos, m.length = m.pos+start-src, m.length-(start-src)
+		}
+		if m.length >= longMinMatch {
+			clipped = append(clipped, m)
+		}
+	}
+	return clipped
+}
@@ -0,0 +1,105 @@
+package reductor
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func Test_longMatcherFind(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	chunk := make([]byte, 10000)
+	rng.Read(chunk)
+	filler := make([]byte, 50000)
+	rng.Read(filler)
+	input := append(append(append([]byte{}, chunk...), filler...), chunk...)
+	var tests = []struct {
+		name   string
+		window int
+		want   []longMatch
+	}{
+		{name: "Within window", window: 1 << 20, want: []longMatch{{pos: 60000, length: 10000, distance: 60000}}},
+		{name: "Beyond window", window: 50000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Split input in two, like consecutive blocks.
+			lm := newLongMatcher(tt.window)
+			got := append(lm.find(input[:30000], 0), lm.find(input, 30000)...)
+			if len(got) != len(tt.want) {
+				t.Fatalf("unexpected matches (got %v want %v)", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("unexpected match (got %v want %v)", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_WriterLongWindow(t *testing.T) {
+	// Copies of a chunk are further apart than the search window, but
+	// within the long window.
+	rng := rand.New(rand.NewSource(2))
+	chunk := make([]byte, 100<<10)
+	rng.Read(chunk)
+	var input []byte
+	for i := 0; i < 3; i++ {
+		filler := make([]byte, 300<<10)
+		rng.Read(filler)
+		input = append(append(input, chunk...), filler...)
+	}
+	sizes := make(map[uint32]int)
+	for _, longWindow := range []uint32{0, 512 << 10} {
+		var compressed bytes.Buffer
+		zw, err := NewWriter(&compressed, Options{LongWindow: longWindow})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Small blocks make both windows slide a few times.
+		zw.blockSize = 64 << 10
+		if _, err := zw.Write(input); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		sizes[longWindow] = compressed.Len()
+		var got bytes.Buffer
+		if err := Decompress(&got, &compressed); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), input) {
+			t.Errorf("long window %d: roundtrip mismatch (got %d bytes want %d bytes)", longWindow, got.Len(), len(input))
+		}
+	}
+	if saved := sizes[0] - sizes[512<<10]; saved < 2*len(chunk)*9/10 {
+		t.Errorf("copies of the chunk were not found (saved %d bytes)", saved)
+	}
+}
+
+func Test_clipLongMatches(t *testing.T) {
+	var tests = []struct {
+		name    string
+		matches []longMatch
+		want    []longMatch
+	}{
+		{name: "After start", matches: []longMatch{{pos: 300, length: 100, distance: 100}}, want: []longMatch{{pos: 300, length: 100, distance: 100}}},
+		{name: "Shortened", matches: []longMatch{{pos: 150, length: 200, distance: 100}}, want: []longMatch{{pos: 200, length: 150, distance: 100}}},
+		{name: "Dropped", matches: []longMatch{{pos: 120, length: 100, distance: 100}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := clipLongMatches(tt.matches, 100)
+			if len(got) != len(tt.want) {
+				t.Fatalf("unexpected matches (got %v want %v)", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("unexpected match (got %v want %v)", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
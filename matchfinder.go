@@ -0,0 +1,303 @@
+package reductor
+
+import "fmt"
+
+// MatchFinder selects the algorithm used to find LZ matches.
+type MatchFinder byte
+
+const (
+	// MatchFinderDefault selects the match finder of the compression
+	// level, or MatchFinderHashChain without one.
+	MatchFinderDefault MatchFinder = iota
+	// MatchFinderHashChain checks up to ChainDepth earlier positions
+	// starting with the same bytes.
+	MatchFinderHashChain
+	// MatchFinderBinaryTree keeps earlier positions sorted in binary trees,
+	// like LZMA's bt4. It finds the longest and closest match within the
+	// window for up to ChainDepth visited nodes, at the cost of speed.
+	MatchFinderBinaryTree
+)
+
+func (mf MatchFinder) valid() bool {
+	return mf <= MatchFinderBinaryTree
+}
+
+func (mf MatchFinder) String() string {
+	switch mf {
+	case MatchFinderDefault:
+		return "default"
+	case MatchFinderHashChain:
+		return "hc"
+	case MatchFinderBinaryTree:
+		return "bt"
+	}
+	return fmt.Sprintf("MatchFinder(%d)", byte(mf))
+}
+
+// hashBits is the size of match finder head tables, in bits.
+const hashBits = 16
+
+// matchFinder finds LZ matches in its input. Writer keeps a single one
+// for the whole stream, so that history is indexed only once.
+type matchFinder interface {
+	// setInput replaces the input with one holding more data appended.
+	setInput(input []byte)
+	// slide drops the first n bytes of input, shifting all positions.
+	// Dropped positions which were not indexed yet are skipped.
+	slide(n int)
+	// longestMatch returns the position and length of a match for the
+	// input at split. Matches are at least minMatchLen long, otherwise
+	// length is 0. They may overlap split, when the input repeats with a
+	// period shorter than the match. Positions must be asked for in
+	// increasing order.
+	longestMatch(split int) (int, int)
+}
+
+// matchParams are the parameters shared by match finders.
+type matchParams struct {
+	input                    []byte
+	minMatchLen, maxMatchLen int
+	// window is the largest distance of a match.
+	window int
+	// depth is the number of candidates checked for every match.
+	depth int
+	// hashLen is the number of bytes hashed, at most 4.
+	hashLen int
+}
+
+func newMatchFinder(kind MatchFinder, input []byte, minMatchLen, maxMatchLen, window, depth int) matchFinder {
+	p := matchParams{
+		minMatchLen: minMatchLen,
+		maxMatchLen: maxMatchLen,
+		window:      window,
+		depth:       depth,
+		hashLen:     max(1, min(minMatchLen, 4)),
+	}
+	var mf matchFinder
+	if kind == MatchFinderBinaryTree {
+		mf = &binaryTree{matchParams: p, head: make([]int32, 1<<hashBits)}
+	} else {
+		mf = &hashChain{matchParams: p, head: make([]int32, 1<<hashBits)}
+	}
+	mf.setInput(input)
+	return mf
+}
+
+// hash returns the hash of hashLen bytes at pos.
+func (p *matchParams) hash(pos int) uint32 {
+	var h uint32
+	for _, b := range p.input[pos : pos+p.hashLen] {
+		h = h<<8 | uint32(b)
+	}
+	return h * 2654435761 >> (32 - hashBits)
+}
+
+// end returns the end of the lookahead buffer at pos.
+func (p *matchParams) end(pos int) int {
+	return min(len(p.input), pos+p.maxMatchLen)
+}
+
+// hashable returns the number of positions with hashLen bytes of input.
+func (p *matchParams) hashable() int {
+	return len(p.input) - p.hashLen + 1
+}
+
+// growLinks extends a table of links to n entries.
+func growLinks(links []int32, n int) []int32 {
+	if n <= len(links) {
+		return links
+	}
+	if n > cap(links) {
+		grown := make([]int32, len(links), max(n, 2*cap(links)))
+		copy(grown, links)
+		links = grown
+	}
+	// Entries past the end may be left over from before a slide.
+	tail := links[len(links):n]
+	for i := range tail {
+		tail[i] = 0
+	}
+	return links[:n]
+}
+
+// slideLinks moves positions held in links n bytes back. Positions which
+// drop off the input become zero.
+func slideLinks(links []int32, n int) []int32 {
+	for i, l := range links {
+		if int(l) > n {
+			links[i] = l - int32(n)
+		} else {
+			links[i] = 0
+		}
+	}
+	return links
+}
+
+// dropLinks drops links of the first n positions and slides the rest.
+func dropLinks(links []int32, n int) []int32 {
+	return slideLinks(links[:copy(links, links[n:])], n)
+}
+
+// hashChain groups positions by a hash of the bytes starting there, and
+// links each group from the most recent position backwards, so that only
+// positions likely to match are checked.
+type hashChain struct {
+	matchParams
+	// head holds the most recent position for every hash, plus one, so
+	// that zero means no position. prev links a position to the previous
+	// one with the same hash, in the same way.
+	head []int32
+	prev []int32
+	// next is the first position not inserted yet.
+	next int
+}
+
+func (hc *hashChain) setInput(input []byte) {
+	hc.input = input
+	hc.prev = growLinks(hc.prev, len(input))
+}
+
+func (hc *hashChain) slide(n int) {
+	hc.input = hc.input[n:]
+	hc.head = slideLinks(hc.head, n)
+	hc.prev = dropLinks(hc.prev, n)
+	hc.next = max(0, hc.next-n)
+}
+
+// insert adds positions up to, but not including, pos.
+func (hc *hashChain) insert(pos int) {
+	last := min(pos, hc.hashable())
+	for ; hc.next < last; hc.next++ {
+		h := hc.hash(hc.next)
+		hc.prev[hc.next] = hc.head[h]
+		hc.head[h] = int32(hc.next + 1)
+	}
+}
+
+// longestMatch checks candidates from the closest one. Of matches with
+// equal lengths the earliest one is returned.
+func (hc *hashChain) longestMatch(split int) (int, int) {
+	end := hc.end(split)
+	if end-split < max(hc.minMatchLen, hc.hashLen) {
+		return 0, 0
+	}
+	hc.insert(split)
+	var position, length int
+	h := hc.hash(split)
+	limit := split - hc.window
+	for cand, n := int(hc.head[h])-1, 0; cand >= 0 && cand >= limit && n < hc.depth; cand, n = int(hc.prev[cand])-1, n+1 {
+		if cand >= split {
+			// Matches may overlap split, but must start before it.
+			continue
+		}
+		l := getMatchLen(hc.input[cand:], hc.input[split:end])
+		if l >= hc.minMatchLen && l >= length && l > 0 {
+			position, length = cand, l
+		}
+	}
+	return position, length
+}
+
+// binaryTree keeps earlier positions with the same hash in a binary search
+// tree, ordered by the input following them. The most recent position is
+// the root and the tree is rebuilt around every new position, so that the
+// path to it passes the positions sharing the longest prefixes with it.
+type binaryTree struct {
+	matchParams
+	// head holds the root of the tree for every hash, plus one, so that
+	// zero means an empty tree. left and right hold children in the same
+	// way. Children are always earlier than their parents.
+	head  []int32
+	left  []int32
+	right []int32
+	// next is the first position not inserted yet.
+	next int
+}
+
+func (bt *binaryTree) setInput(input []byte) {
+	bt.input = input
+	bt.left = growLinks(bt.left, len(input))
+	bt.right = growLinks(bt.right, len(input))
+}
+
+func (bt *binaryTree) slide(n int) {
+	bt.input = bt.input[n:]
+	bt.head = slideLinks(bt.head, n)
+	bt.left = dropLinks(bt.left, n)
+	bt.right = dropLinks(bt.right, n)
+	bt.next = max(0, bt.next-n)
+}
+
+// treeMaxLen is the number of bytes binaryTree compares when sorting
+// positions. Comparing up to maxMatchLen would make inserting every
+// position of a long repetition quadratic.
+const treeMaxLen = 256
+
+// longestMatch returns the longest match found while inserting split. Of
+// matches with equal lengths the closest one is returned. Matches reaching
+// treeMaxLen are extended up to maxMatchLen, without looking for others.
+func (bt *binaryTree) longestMatch(split int) (int, int) {
+	for last := min(split, bt.hashable()); bt.next < last; bt.next++ {
+		bt.insert(bt.next)
+	}
+	end := bt.end(split)
+	if end-split < max(bt.minMatchLen, bt.hashLen) {
+		return 0, 0
+	}
+	bt.next = split + 1
+	position, length := bt.insert(split)
+	if length == treeMaxLen {
+		length = getMatchLen(bt.input[position:], bt.input[split:end])
+	}
+	return position, length
+}
+
+// insert makes pos the root of its tree and returns the best match seen
+// on the way. Nodes further than the window or than depth steps away are
+// dropped from the tree.
+func (bt *binaryTree) insert(pos int) (int, int) {
+	var position, length int
+	end := min(bt.end(pos), pos+treeMaxLen)
+	h := bt.hash(pos)
+	cand := int(bt.head[h]) - 1
+	bt.head[h] = int32(pos + 1)
+	// smaller and larger are the slots for the next node found to sort
+	// before or after pos. Nodes between them share at least lenSmaller
+	// and lenLarger bytes with pos.
+	smaller, larger := &bt.left[pos], &bt.right[pos]
+	var lenSmaller, lenLarger int
+	limit := pos - bt.window
+	for n := 0; ; n++ {
+		// Positions from pos on are not matches, even when pos was
+		// inserted before.
+		if cand < 0 || cand >= pos || cand < limit || n >= bt.depth {
+			*smaller, *larger = 0, 0
+			break
+		}
+		l := min(lenSmaller, lenLarger)
+		for pos+l < end && bt.input[cand+l] == bt.input[pos+l] {
+			l++
+		}
+		if l >= bt.minMatchLen && l > length {
+			position, length = cand, l
+		}
+		if pos+l == end {
+			// cand sorts the same as pos up to the compared length, so pos
+			// takes over its children.
+			*smaller, *larger = bt.left[cand], bt.right[cand]
+			break
+		}
+		if bt.input[cand+l] < bt.input[pos+l] {
+			*smaller = int32(cand + 1)
+			smaller = &bt.right[cand]
+			cand = int(bt.right[cand]) - 1
+			lenSmaller = l
+		} else {
+			*larger = int32(cand + 1)
+			larger = &bt.left[cand]
+			cand = int(bt.left[cand]) - 1
+			lenLarger = l
+		}
+	}
+	return position, length
+}
@@ -0,0 +1,84 @@
+package reductor
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// longestMatchNaive checks every position of the window, like the linear
+// scan the match finders replaced. Of matches with equal lengths it
+// returns the closest one if closest is set, the earliest one otherwise.
+func longestMatchNaive(input []byte, split, end, minMatchLen, window int, closest bool) (int, int) {
+	var position, length int
+	for cand := max(0, split-window); cand < split; cand++ {
+		l := getMatchLen(input[cand:], input[split:end])
+		if l >= minMatchLen && l > 0 && (l > length || closest && l == length) {
+			position, length = cand, l
+		}
+	}
+	return position, length
+}
+
+func Test_matchFinderMatchesNaive(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	// A small alphabet produces many matches and hash collisions.
+	abc := make([]byte, 5000)
+	for i := range abc {
+		abc[i] = "abc"[rng.Intn(3)]
+	}
+	var words []byte
+	for len(words) < 5000 {
+		words = append(words, []string{"hash", "chain", "binary", "tree", "a", "aa", "ab"}[rng.Intn(7)]...)
+		words = append(words, " .,"[rng.Intn(3)])
+	}
+	for _, input := range [][]byte{abc, words} {
+		for _, kind := range []MatchFinder{MatchFinderHashChain, MatchFinderBinaryTree} {
+			for _, minMatchLen := range []int{1, 2, 3, 4, 6} {
+				mf := newMatchFinder(kind, input, minMatchLen, 40, 300, len(input))
+				for split := 0; split < len(input); split++ {
+					end := min(len(input), split+40)
+					gotPos, gotLen := mf.longestMatch(split)
+					wantPos, wantLen := longestMatchNaive(input, split, end, minMatchLen, 300, kind == MatchFinderBinaryTree)
+					if gotPos != wantPos || gotLen != wantLen {
+						t.Fatalf("%v, min match %d, split %d: got (%d, %d) want (%d, %d)", kind, minMatchLen, split, gotPos, gotLen, wantPos, wantLen)
+					}
+				}
+			}
+		}
+	}
+}
+
+func Test_hashChainDepth(t *testing.T) {
+	// The longest match is the furthest one, beyond a chain depth of 1.
+	input := []byte("abcdefgh abcd abcd abcdefgh")
+	split := len(input) - len("abcdefgh")
+	var tests = []struct {
+		depth   int
+		wantPos int
+		wantLen int
+	}{
+		{depth: 1, wantPos: 14, wantLen: 4},
+		{depth: 3, wantPos: 0, wantLen: 8},
+	}
+	for _, tt := range tests {
+		mf := newMatchFinder(MatchFinderHashChain, input, 4, 255, len(input), tt.depth)
+		p, l := mf.longestMatch(split)
+		if p != tt.wantPos || l != tt.wantLen {
+			t.Errorf("depth %d: got (%d, %d) want (%d, %d)", tt.depth, p, l, tt.wantPos, tt.wantLen)
+		}
+	}
+}
+
+func Benchmark_bytesToValues(b *testing.B) {
+	input := determinismCorpus()["text"]
+	for _, window := range []uint32{DefaultSearchSize, 65535} {
+		b.Run(fmt.Sprint(window), func(b *testing.B) {
+			opts := Options{SearchSize: window}.withDefaults()
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				parse(input, opts)
+			}
+		})
+	}
+}
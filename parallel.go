@@ -0,0 +1,192 @@
+package reductor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// With Options.Concurrency set, Writer compresses blocks of input as jobs
+// running in parallel, each with a match finder of its own, so matches
+// reach into earlier blocks only as far as the window is primed. Long
+// distance matches are still found in order, as fingerprinting is fast
+// compared to the rest of compression. Jobs are written out in order, so
+// the output does not depend on the number of goroutines.
+
+// job compresses a block of input on a goroutine of its own.
+type job struct {
+	enc *blockWriter
+	// lz and graphviz collect dumps of the job, which are written out
+	// in order with its blocks.
+	lz, graphviz bytes.Buffer
+	// size is the size of the input.
+	size int
+	done chan error
+}
+
+// startJob starts compressing pending input, after waiting for the oldest
+// job if there are too many running.
+func (z *Writer) startJob() error {
+	if len(z.jobs) == z.opts.Concurrency {
+		if err := z.finishJob(); err != nil {
+			return err
+		}
+	}
+	// Matches reach into history only if the window is primed.
+	base := z.start
+	if z.opts.PrimeWindow {
+		base = max(0, z.start-int(z.opts.SearchSize))
+	}
+	var long []longMatch
+	if z.lm != nil {
+		long = z.lm.find(z.buf, z.start)
+		if !z.opts.PrimeWindow {
+			long = clipLongMatches(long, z.start)
+		}
+		for i := range long {
+			long[i].pos -= base
+		}
+	}
+	opts := z.opts
+	j := &job{size: len(z.buf) - z.start, done: make(chan error, 1)}
+	if opts.LZ != ioutil.Discard {
+		opts.LZ = &j.lz
+	}
+	if opts.Graphviz != ioutil.Discard {
+		opts.Graphviz = &j.graphviz
+	}
+	j.enc = newBlockWriter(opts)
+	input, start := z.buf[base:], z.start-base
+	go func() {
+		mf := newMatchFinder(opts.MatchFinder, nil, int(opts.MinMatch), int(opts.MaxMatch), int(opts.SearchSize), int(opts.ChainDepth))
+		j.done <- j.enc.writeInput(mf, input, start, long)
+	}()
+	z.jobs = append(z.jobs, j)
+
+	// Jobs read the buffer until they finish, so instead of sliding it in
+	// place, the history is moved to a new one.
+	z.start = len(z.buf)
+	if window := int(z.opts.window()); len(z.buf) >= 2*window {
+		n := len(z.buf) - window
+		z.buf = append(make([]byte, 0, 2*window), z.buf[n:]...)
+		if z.lm != nil {
+			z.lm.slide(n)
+		}
+		z.start = window
+	}
+	return nil
+}
+
+// finishJob waits for the oldest job and writes out its blocks.
+func (z *Writer) finishJob() error {
+	j := z.jobs[0]
+	z.jobs = z.jobs[1:]
+	if err := <-j.done; err != nil {
+		return err
+	}
+	if _, err := z.opts.LZ.Write(j.lz.Bytes()); err != nil {
+		return err
+	}
+	z.opts.Graphviz.Write(j.graphviz.Bytes())
+	e := indexEntry{offset: z.written, size: j.enc.out.Len(), contentSize: j.size}
+	if n := len(z.index); n > 0 {
+		e.content = z.index[n-1].content + int64(z.index[n-1].contentSize)
+	}
+	z.index = append(z.index, e)
+	return z.write(j.enc.out.Bytes())
+}
+
+// DecompressParallel decompresses size bytes of data produced by Compress,
+// held by src, to dst. Streams written with Options.Index are decoded up to
+// concurrency blocks of input at a time, others are decompressed like
+// with Decompress.
+func DecompressParallel(dst io.Writer, src io.ReaderAt, size int64, concurrency int) error {
+	h, index, err := readIndex(src, size)
+	if err != nil && !errors.Is(err, ErrHeader) {
+		return err
+	}
+	if err != nil || h.flags&flagIndex == 0 {
+		return Decompress(dst, io.NewSectionReader(src, 0, size))
+	}
+	concurrency = max(1, concurrency)
+	type result struct {
+		data []byte
+		err  error
+	}
+	sum := h.checksum().newHash()
+	// pending holds results of blocks being decoded, in order.
+	var pending []chan result
+	write := func() error {
+		r := <-pending[0]
+		pending = pending[1:]
+		if r.err != nil {
+			return r.err
+		}
+		if sum != nil {
+			sum.Write(r.data)
+		}
+		_, err := dst.Write(r.data)
+		return err
+	}
+	for _, e := range index {
+		if len(pending) == concurrency {
+			if err := write(); err != nil {
+				return err
+			}
+		}
+		c := make(chan result, 1)
+		go func(e indexEntry) {
+			data, err := decodeIndexed(h, src, e)
+			c <- result{data, err}
+		}(e)
+		pending = append(pending, c)
+	}
+	for len(pending) > 0 {
+		if err := write(); err != nil {
+			return err
+		}
+	}
+
+	// The end marker and the trailer follow the last block.
+	end := int64(len(h.marshal()))
+	if n := len(index); n > 0 {
+		end = index[n-1].offset + int64(index[n-1].size)
+	}
+	trailer := make([]byte, 4+h.checksum().size())
+	if _, err := src.ReadAt(trailer, end); err != nil {
+		return noEOF(err)
+	}
+	if binary.BigEndian.Uint32(trailer) != 0 {
+		return ErrCorrupt
+	}
+	if sum != nil && !bytes.Equal(trailer[4:], sum.Sum(nil)) {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// decodeIndexed decodes the blocks described by e.
+func decodeIndexed(h header, ra io.ReaderAt, e indexEntry) ([]byte, error) {
+	z := &Reader{br: newBinaryReader(io.NewSectionReader(ra, e.offset, int64(e.size)))}
+	// Sizes are verified against the index instead.
+	h.flags &^= flagContentSize | flagIndex
+	z.setHeader(h)
+	data := make([]byte, 0, e.contentSize)
+	for len(data) < e.contentSize {
+		if err := z.readBlock(); err == io.EOF {
+			// The end marker is not part of any block.
+			return nil, ErrCorrupt
+		} else if err != nil {
+			return nil, err
+		}
+		data = append(data, z.hist[z.off:]...)
+		z.off = len(z.hist)
+	}
+	// The blocks must take all of their space, and not decode to more.
+	if len(data) > e.contentSize || z.br.r.readFull(make([]byte, 1)) != io.EOF {
+		return nil, ErrCorrupt
+	}
+	return data, nil
+}
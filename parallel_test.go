@@ -0,0 +1,87 @@
+package reductor
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func Test_WriterConcurrency(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	chunk := make([]byte, 5000)
+	rng.Read(chunk)
+	var input []byte
+	for i := 0; i < 20; i++ {
+		input = append(input, bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 50)...)
+		input = append(input, chunk[:rng.Intn(len(chunk))]...)
+	}
+	var tests = []struct {
+		name string
+		opts Options
+	}{
+		{name: "Independent blocks", opts: Options{}},
+		{name: "Primed window", opts: Options{PrimeWindow: true}},
+		{name: "Long window", opts: Options{LongWindow: 1 << 20}},
+		{name: "Long window primed", opts: Options{LongWindow: 1 << 20, PrimeWindow: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want []byte
+			for _, concurrency := range []int{1, 2, 3, 8} {
+				var compressed bytes.Buffer
+				opts := tt.opts
+				opts.Concurrency = concurrency
+				zw, err := NewWriter(&compressed, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				zw.blockSize = 10000
+				if _, err := zw.Write(input); err != nil {
+					t.Fatal(err)
+				}
+				if err := zw.Close(); err != nil {
+					t.Fatal(err)
+				}
+				// The output does not depend on the number of goroutines.
+				if want == nil {
+					want = append([]byte{}, compressed.Bytes()...)
+				} else if !bytes.Equal(compressed.Bytes(), want) {
+					t.Errorf("concurrency %d: output differs from concurrency 1", concurrency)
+				}
+				var got bytes.Buffer
+				if err := Decompress(&got, &compressed); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.Bytes(), input) {
+					t.Errorf("concurrency %d: roundtrip mismatch (got %d bytes want %d bytes)", concurrency, got.Len(), len(input))
+				}
+			}
+		})
+	}
+}
+
+func Test_WriterConcurrencyPrimeWindow(t *testing.T) {
+	// Every block repeats the one before, which only primed blocks see.
+	block := make([]byte, 2000)
+	rand.New(rand.NewSource(5)).Read(block)
+	input := bytes.Repeat(block, 10)
+	sizes := make(map[bool]int)
+	for _, prime := range []bool{false, true} {
+		var compressed bytes.Buffer
+		zw, err := NewWriter(&compressed, Options{Concurrency: 2, PrimeWindow: prime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw.blockSize = len(block)
+		if _, err := zw.Write(input); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		sizes[prime] = compressed.Len()
+	}
+	if sizes[false] < len(input) || sizes[true] > 2*len(block) {
+		t.Errorf("unexpected sizes (got %d unprimed and %d primed for %d bytes)", sizes[false], sizes[true], len(input))
+	}
+}
@@ -0,0 +1,263 @@
+package reductor
+
+import (
+	"fmt"
+	"math"
+)
+
+// Parser selects how the LZ stage chooses between the matches found.
+type Parser byte
+
+const (
+	// ParserDefault selects the parser of the compression level, or
+	// ParserLazy without one.
+	ParserDefault Parser = iota
+	// ParserLazy takes a match only if the next position does not start
+	// a longer one, like zlib levels 4-9.
+	ParserLazy
+	// ParserLazy2 also checks the position after next, which is worth
+	// two literals only if its match is longer by at least two bytes.
+	ParserLazy2
+	// ParserGreedy takes the longest match at every position.
+	ParserGreedy
+	// ParserOptimal chooses the cheapest sequence of literals and matches
+	// according to bit prices of the Huffman codes, refining the codes
+	// over several passes. It is the slowest.
+	ParserOptimal
+)
+
+func (p Parser) valid() bool {
+	return p <= ParserOptimal
+}
+
+func (p Parser) String() string {
+	switch p {
+	case ParserDefault:
+		return "default"
+	case ParserLazy:
+		return "lazy"
+	case ParserLazy2:
+		return "lazy2"
+	case ParserGreedy:
+		return "greedy"
+	case ParserOptimal:
+		return "optimal"
+	}
+	return fmt.Sprintf("Parser(%d)", byte(p))
+}
+
+// lazyMaxLen is the length of a match good enough to be taken without
+// looking for a longer one, which rarely pays off past it.
+const lazyMaxLen = 32
+
+// lookahead is the number of positions after a match checked for a
+// longer one.
+func (p Parser) lookahead() int {
+	switch p {
+	case ParserLazy:
+		return 1
+	case ParserLazy2:
+		return 2
+	}
+	return 0
+}
+
+// foundMatch is a match for the input at pos. length is 0 if none was found.
+type foundMatch struct {
+	pos      int
+	position int
+	length   int
+}
+
+// matchCache remembers matches found ahead of the current position, as
+// match finders can only be asked about every position once.
+type matchCache struct {
+	mf       matchFinder
+	minMatch int
+	found    []foundMatch
+	// end is the end of the input parsed, matches are cut short at it.
+	end int
+}
+
+// next returns the match for pos, the position being parsed, and drops
+// matches before it. Positions must not decrease between calls.
+func (mc *matchCache) next(pos int) foundMatch {
+	for len(mc.found) > 0 && mc.found[0].pos < pos {
+		mc.found = mc.found[1:]
+	}
+	return mc.get(pos)
+}
+
+// get returns the match for pos, which must not be before the position
+// being parsed. Matches looked up ahead of it are kept for later calls.
+func (mc *matchCache) get(pos int) foundMatch {
+	for _, f := range mc.found {
+		if f.pos == pos {
+			return mc.cut(f)
+		}
+	}
+	f := foundMatch{pos: pos}
+	if p, l := mc.mf.longestMatch(pos); pos > mc.minMatch && l > 0 {
+		f.position, f.length = p, l
+	}
+	mc.found = append(mc.found, f)
+	return mc.cut(f)
+}
+
+// cut shortens f to end at mc.end, if it is still long enough.
+func (mc *matchCache) cut(f foundMatch) foundMatch {
+	if f.pos+f.length > mc.end {
+		f.length = mc.end - f.pos
+		if f.length < max(1, mc.minMatch) {
+			f.length = 0
+		}
+	}
+	return f
+}
+
+// optimalPasses is the number of times ParserOptimal parses a block, each
+// time with prices from the codes of the previous parse.
+const optimalPasses = 3
+
+// prices are estimated costs of symbols in bits.
+type prices struct {
+	litLen []int
+	dist   []int
+}
+
+// newPrices returns prices of Huffman codes built for values. Symbols
+// absent from values get the longest code length.
+func newPrices(values []Value, limit int) prices {
+	litLen, dist := countFreqs(values)
+	p := prices{
+		litLen: make([]int, len(litLen)),
+		dist:   make([]int, len(dist)),
+	}
+	for i, l := range huffmanCodeLengths(litLen, limit) {
+		p.litLen[i] = int(l)
+		if l == 0 {
+			p.litLen[i] = limit
+		}
+	}
+	for i, l := range huffmanCodeLengths(dist, limit) {
+		p.dist[i] = int(l)
+		if l == 0 {
+			p.dist[i] = limit
+		}
+	}
+	return p
+}
+
+func (p *prices) literal(b byte) int {
+	return p.litLen[b]
+}
+
+// match returns the price of a match, given the repeat distances before it.
+func (p *prices) match(length int, distance uint32, reps *repHistory) int {
+	lsym, _, lbits := bucket(uint32(length), lengthMantissa)
+	dsym, _, dbits := distanceSymbol(distance, reps)
+	return p.litLen[256+lsym] + int(lbits) + p.dist[dsym] + int(dbits)
+}
+
+// optimalLengths is the length of a match ParserOptimal takes without
+// considering alternatives. Checking every length and every position
+// of long matches would make parsing quadratic.
+const optimalLengths = 256
+
+// optimalParse converts input[start:mc.end] to the cheapest values it finds.
+// Every position may start a literal, a match of any length up to the
+// longest one found there, at the same distance, or a match at one of the
+// repeat distances. The first prices come from a lazy parse. reps holds
+// the repeat distances before input[start] and is kept up to date.
+func optimalParse(mc *matchCache, reps *repHistory, input []byte, start int, opts Options) []Value {
+	n := mc.end - start
+	matches := make([]foundMatch, n)
+	for i := 0; i < n; i++ {
+		matches[i] = mc.next(start + i)
+		if matches[i].length > optimalLengths {
+			// Positions within a long match are not worth looking at.
+			i += matches[i].length - 1
+		}
+	}
+	values := make([]Value, 0, n)
+	for i := 0; i < n; {
+		m := matches[i]
+		if m.length == 0 || i+1 < n && matches[i+1].length > m.length {
+			values = append(values, NewValue(true, input[start+i], 1, 0))
+			i++
+			continue
+		}
+		values = append(values, NewValue(false, 0, uint16(m.length), uint32(start+i-m.position)))
+		i += m.length
+	}
+
+	// cost[i] is the price of encoding input[start:start+i], reached with
+	// a literal if length[i] is 0, or a match of length[i] at distance[i].
+	// hist[i] holds the repeat distances after it.
+	cost := make([]int, n+1)
+	length := make([]int, n+1)
+	distance := make([]uint32, n+1)
+	hist := make([]repHistory, n+1)
+	minLen := max(1, int(opts.MinMatch))
+	window := int(opts.window())
+	for pass := 0; pass < optimalPasses; pass++ {
+		p := newPrices(values, int(opts.MaxCodeLength))
+		hist[0] = *reps
+		for i := 1; i <= n; i++ {
+			cost[i] = math.MaxInt32
+		}
+		// relax records reaching i+l with a match from i, if it is cheaper.
+		relax := func(i, l int, d uint32) {
+			if c := cost[i] + p.match(l, d, &hist[i]); c < cost[i+l] {
+				cost[i+l], length[i+l], distance[i+l] = c, l, d
+				hist[i+l] = hist[i]
+				hist[i+l].update(d)
+			}
+		}
+		for i := 0; i < n; i++ {
+			if c := cost[i] + p.literal(input[start+i]); c < cost[i+1] {
+				cost[i+1], length[i+1] = c, 0
+				hist[i+1] = hist[i]
+			}
+			end := min(mc.end, start+i+int(opts.MaxMatch))
+			for _, d := range hist[i] {
+				if int(d) > start+i || int(d) > window {
+					continue
+				}
+				rl := getMatchLen(input[start+i-int(d):], input[start+i:end])
+				for l := minLen; l <= min(rl, optimalLengths); l++ {
+					relax(i, l, d)
+				}
+				if rl > optimalLengths {
+					relax(i, rl, d)
+				}
+			}
+			m := matches[i]
+			dist := uint32(start + i - m.position)
+			if m.length > optimalLengths {
+				// Long matches are always taken whole, see above.
+				relax(i, m.length, dist)
+				i += m.length - 1
+				continue
+			}
+			for l := minLen; l <= m.length; l++ {
+				relax(i, l, dist)
+			}
+		}
+		values = values[:0]
+		for i := n; i > 0; {
+			if length[i] == 0 {
+				values = append(values, NewValue(true, input[start+i-1], 1, 0))
+				i--
+				continue
+			}
+			values = append(values, NewValue(false, 0, uint16(length[i]), distance[i]))
+			i -= length[i]
+		}
+		for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+	*reps = hist[n]
+	return values
+}
@@ -0,0 +1,150 @@
+package reductor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// parse converts input to values as configured by opts.
+func parse(input []byte, opts Options) []Value {
+	mf := newMatchFinder(opts.MatchFinder, input, int(opts.MinMatch), int(opts.MaxMatch), int(opts.SearchSize), int(opts.ChainDepth))
+	return bytesToValues(mf, input, 0, opts, nil)
+}
+
+func Test_bytesToValuesParser(t *testing.T) {
+	var tests = []struct {
+		name   string
+		input  string
+		parser Parser
+		want   string
+	}{
+		{
+			name:   "Greedy takes the first match",
+			input:  "Xbcdefg-abcZ+abcdefg",
+			parser: ParserGreedy,
+			want:   "Xbcdefg-abcZ+<5,3><13,4>",
+		},
+		{
+			name:   "Lazy waits for a longer match",
+			input:  "Xbcdefg-abcZ+abcdefg",
+			parser: ParserLazy,
+			want:   "Xbcdefg-abcZ+a<13,6>",
+		},
+		{
+			name:   "Lazy misses a match two bytes later",
+			input:  "Xcdefgh-abcZ+abcdefgh",
+			parser: ParserLazy,
+			want:   "Xcdefgh-abcZ+<5,3><14,5>",
+		},
+		{
+			name:   "Two-step lazy finds a match two bytes later",
+			input:  "Xcdefgh-abcZ+abcdefgh",
+			parser: ParserLazy2,
+			want:   "Xcdefgh-abcZ+ab<14,6>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{MinMatch: 3, Parser: tt.parser}.withDefaults()
+			values := parse([]byte(tt.input), opts)
+			got := ""
+			for _, v := range values {
+				got += fmt.Sprintf("%v", v)
+			}
+			if got != tt.want {
+				t.Errorf("unexpected repr (got '%s' want '%s')", got, tt.want)
+			}
+			if string(ValuesToBytes(values)) != tt.input {
+				t.Errorf("roundtrip mismatch")
+			}
+		})
+	}
+}
+
+// recordData returns n fixed-size binary records, like rows of a table,
+// whose fields change independently.
+func recordData(n int) []byte {
+	rng := rand.New(rand.NewSource(4))
+	names := []string{"alpha\x00\x00\x00", "beta\x00\x00\x00\x00", "gamma\x00\x00\x00"}
+	var data []byte
+	for i := 0; i < n; i++ {
+		var rec [24]byte
+		binary.LittleEndian.PutUint32(rec[0:], uint32(1000+i))
+		binary.LittleEndian.PutUint16(rec[4:], uint16(rng.Intn(4)))
+		binary.LittleEndian.PutUint32(rec[8:], uint32(rng.Intn(1<<16)))
+		copy(rec[12:], names[rng.Intn(len(names))])
+		data = append(data, rec[:]...)
+	}
+	return data
+}
+
+func Test_bytesToValuesRecords(t *testing.T) {
+	input := recordData(2000)
+	for _, parser := range []Parser{ParserGreedy, ParserLazy, ParserLazy2, ParserOptimal} {
+		t.Run(parser.String(), func(t *testing.T) {
+			values := parse(input, Options{MinMatch: 3, Parser: parser}.withDefaults())
+			if !bytes.Equal(ValuesToBytes(values), input) {
+				t.Fatal("roundtrip mismatch")
+			}
+			// Matches with the previous record are at the last distance,
+			// other fields repeat further back.
+			_, dist := countFreqs(values)
+			var reps, pointers int
+			for sym, n := range dist {
+				if sym < numReps {
+					reps += n
+				}
+				pointers += n
+			}
+			if reps < pointers*2/5 {
+				t.Errorf("too few repeat distances (got %d of %d pointers)", reps, pointers)
+			}
+		})
+	}
+}
+
+func Test_optimalParse(t *testing.T) {
+	inputs := map[string][]byte{
+		"words":  benchmarkText(1 << 16),
+		"source": bytes.Repeat([]byte("for i := 0; i < n; i++ {\n\tif x[i] != y[i] {\n\t\treturn false\n\t}\n}\n"), 300),
+	}
+	for name, input := range inputs {
+		t.Run(name, func(t *testing.T) {
+			sizes := make(map[Parser]int)
+			for _, parser := range []Parser{ParserLazy, ParserOptimal} {
+				opts := Options{Parser: parser}.withDefaults()
+				if got := ValuesToBytes(parse(input, opts)); !bytes.Equal(got, input) {
+					t.Fatalf("%v: roundtrip mismatch", parser)
+				}
+				var compressed bytes.Buffer
+				if err := Compress(&compressed, bytes.NewReader(input), opts); err != nil {
+					t.Fatal(err)
+				}
+				sizes[parser] = compressed.Len()
+			}
+			if sizes[ParserOptimal] > sizes[ParserLazy] {
+				t.Errorf("optimal parse larger than lazy parse (%d > %d bytes)", sizes[ParserOptimal], sizes[ParserLazy])
+			}
+		})
+	}
+}
+
+func Benchmark_CompressParser(b *testing.B) {
+	input := benchmarkText(1 << 20)
+	for _, parser := range []Parser{ParserGreedy, ParserLazy, ParserLazy2, ParserOptimal} {
+		b.Run(parser.String(), func(b *testing.B) {
+			var compressed bytes.Buffer
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				compressed.Reset()
+				if err := Compress(&compressed, bytes.NewReader(input), Options{Parser: parser}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(input))/float64(compressed.Len()), "ratio")
+		})
+	}
+}
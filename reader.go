@@ -0,0 +1,207 @@
+package reductor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// legacyWindow is the largest distance of headerless streams, which store
+// distances in two bytes.
+const legacyWindow = 1<<16 - 1
+
+// legacyMaxMatch is the longest match of headerless streams, which store
+// lengths in one byte.
+const legacyMaxMatch = 255
+
+// Reader is an io.Reader that decompresses data read from the
+// underlying reader one block at a time.
+type Reader struct {
+	br     binaryReader
+	header header
+	// legacy is set for headerless streams written before the container
+	// format was introduced. They consist of a single table and values.
+	legacy bool
+	window int
+
+	// hist holds decoded data. hist[off:] was not returned to the caller yet.
+	hist  []byte
+	off   int
+	total uint64
+	// sum accumulates the content checksum, it is nil if disabled.
+	sum hash.Hash
+
+	err error
+}
+
+// NewReader returns a Reader decompressing data from r. It reads the
+// stream header and returns ErrHeader if r does not hold reductor data.
+func NewReader(r io.Reader) (*Reader, error) {
+	return NewReaderDict(r, nil)
+}
+
+// NewReaderDict is like NewReader for streams compressed with
+// Options.Dictionary set to dict. It returns ErrDictionary if the stream
+// was compressed with a different dictionary, or with one while dict is
+// empty. dict is ignored for streams compressed without one.
+func NewReaderDict(r io.Reader, dict []byte) (*Reader, error) {
+	br := bufio.NewReader(r)
+	z := &Reader{br: newBinaryReader(br)}
+	prefix, _ := br.Peek(len(magic))
+	switch {
+	case bytes.Equal(prefix, magic[:]):
+		h, err := readHeader(br)
+		if err != nil {
+			return nil, err
+		}
+		z.setHeader(h)
+		z.sum = h.checksum().newHash()
+		if h.flags&flagDictionary != 0 {
+			if len(dict) == 0 || DictionaryID(dict) != h.dictionary {
+				return nil, fmt.Errorf("%w: stream needs dictionary %08x", ErrDictionary, h.dictionary)
+			}
+			// Pointers reach into the dictionary as if it preceded the
+			// content, which it does not return.
+			z.hist = append(z.hist, dictionaryTail(dict, z.window)...)
+			z.off = len(z.hist)
+		}
+	case len(prefix) > 0:
+		z.legacy = true
+		// Pointers of headerless streams may reach as far as they can encode.
+		z.window = legacyWindow
+		var err error
+		if z.br.legacy, err = z.br.readLegacyTable(); err != nil {
+			if errors.Is(err, ErrCorrupt) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, ErrHeader
+			}
+			return nil, err
+		}
+	default:
+		return nil, ErrHeader
+	}
+	return z, nil
+}
+
+// setHeader prepares z to decode blocks of a stream with header h.
+func (z *Reader) setHeader(h header) {
+	z.header = h
+	z.window = int(h.window)
+	if h.version == 1 {
+		z.br.litLenSize, z.br.distSize, z.br.numReps = 256+v1NumLengthSyms, v1NumDistanceSyms, 0
+	}
+	z.br.version = h.version
+}
+
+// Read reads decompressed data into p.
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.off == len(z.hist) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.readBlock()
+	}
+	n := copy(p, z.hist[z.off:])
+	z.off += n
+	return n, nil
+}
+
+// readBlock decodes the next block and appends it to the history.
+func (z *Reader) readBlock() error {
+	var (
+		values []Value
+		raw    []byte
+		err    error
+	)
+	if z.legacy {
+		values, err = z.br.readLegacyValues(blockSize)
+	} else {
+		values, raw, err = z.br.readBlock()
+	}
+	if err == io.EOF {
+		return z.readTrailer()
+	}
+	if err != nil {
+		return err
+	}
+
+	// All of the history was returned already, so it is safe to drop
+	// everything except what pointers may still refer to. Like Writer, it
+	// is done only once at least a window worth of data can be dropped.
+	if len(z.hist) >= 2*z.window {
+		z.hist = z.hist[:copy(z.hist, z.hist[len(z.hist)-z.window:])]
+	}
+	z.off = len(z.hist)
+
+	if raw != nil {
+		z.hist = append(z.hist, raw...)
+	} else if err := checkValues(values, len(z.hist), z.window, z.maxBlockSize()); err != nil {
+		return err
+	} else {
+		z.hist = appendValues(z.hist, values)
+	}
+	if err := z.checkBlock(z.hist[z.off:]); err != nil {
+		// Do not return any of the invalid data.
+		z.hist = z.hist[:z.off]
+		return err
+	}
+	return nil
+}
+
+// maxBlockSize returns the largest amount of data a block may decode to.
+// Writer codes at most a block of input at a time, while headerless
+// streams are read blockSize values at a time.
+func (z *Reader) maxBlockSize() int {
+	if z.legacy {
+		return blockSize * legacyMaxMatch
+	}
+	return blockSize
+}
+
+// checkBlock verifies freshly decoded block data against the block
+// checksum and the content size.
+func (z *Reader) checkBlock(block []byte) error {
+	if z.header.checksum() != ChecksumNone {
+		var sum [4]byte
+		if err := z.br.r.readFull(sum[:]); err != nil {
+			return noEOF(err)
+		}
+		if binary.BigEndian.Uint32(sum[:]) != blockChecksum(block) {
+			return ErrChecksum
+		}
+	}
+	if z.sum != nil {
+		z.sum.Write(block)
+	}
+	z.total += uint64(len(block))
+	if z.header.flags&flagContentSize != 0 && z.total > z.header.contentSize {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// readTrailer verifies the content checksum and size once the stream
+// ended. It returns io.EOF if they match.
+func (z *Reader) readTrailer() error {
+	if z.sum != nil {
+		sum := make([]byte, z.sum.Size())
+		if err := z.br.r.readFull(sum); err != nil {
+			return noEOF(err)
+		}
+		if !bytes.Equal(sum, z.sum.Sum(nil)) {
+			return ErrChecksum
+		}
+	}
+	if z.header.flags&flagContentSize != 0 && z.total != z.header.contentSize {
+		return io.ErrUnexpectedEOF
+	}
+	if z.header.flags&flagIndex != 0 {
+		if err := skipIndex(z.br.r); err != nil {
+			return err
+		}
+	}
+	return io.EOF
+}
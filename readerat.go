@@ -0,0 +1,127 @@
+package reductor
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+// ErrNoIndex is returned by NewReaderAt for streams written without
+// Options.Index.
+var ErrNoIndex = errors.New("reductor: stream has no index")
+
+// ReaderAt gives random access to the content of a stream written with
+// Options.Index. It implements io.ReaderAt, decoding only the blocks
+// covering the requested range, and io.ReadSeeker. Blocks are verified
+// with their checksums, but the content checksum, which covers all of
+// the content, is not.
+type ReaderAt struct {
+	ra     io.ReaderAt
+	header header
+	index  []indexEntry
+	size   int64
+	// off is the offset of Read.
+	off int64
+
+	// The last block decoded is cached, as reads often come in order.
+	mu     sync.Mutex
+	cached int
+	data   []byte
+}
+
+// NewReaderAt returns a ReaderAt for a stream of size bytes held by ra.
+// It reads the stream header and the index, and returns ErrNoIndex if
+// there is none.
+func NewReaderAt(ra io.ReaderAt, size int64) (*ReaderAt, error) {
+	h, index, err := readIndex(ra, size)
+	if err != nil {
+		return nil, err
+	}
+	if h.flags&flagIndex == 0 {
+		return nil, ErrNoIndex
+	}
+	z := &ReaderAt{ra: ra, header: h, index: index, cached: -1}
+	if n := len(index); n > 0 {
+		z.size = index[n-1].content + int64(index[n-1].contentSize)
+	}
+	return z, nil
+}
+
+// Size returns the size of the content.
+func (z *ReaderAt) Size() int64 {
+	return z.size
+}
+
+// ReadAt reads len(p) bytes of content starting at off into p. It may be
+// called concurrently.
+func (z *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("reductor: negative offset")
+	}
+	n := 0
+	// i is the first block ending after off.
+	i := sort.Search(len(z.index), func(i int) bool {
+		return z.index[i].content+int64(z.index[i].contentSize) > off
+	})
+	for ; n < len(p) && i < len(z.index); i++ {
+		data, err := z.block(i)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[off+int64(n)-z.index[i].content:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// block returns the content of the i-th block of input.
+func (z *ReaderAt) block(i int) ([]byte, error) {
+	z.mu.Lock()
+	if z.cached == i {
+		defer z.mu.Unlock()
+		return z.data, nil
+	}
+	z.mu.Unlock()
+	data, err := decodeIndexed(z.header, z.ra, z.index[i])
+	if err != nil {
+		return nil, err
+	}
+	z.mu.Lock()
+	z.cached, z.data = i, data
+	z.mu.Unlock()
+	return data, nil
+}
+
+// Read reads content into p, starting at the offset set by Seek.
+func (z *ReaderAt) Read(p []byte) (int, error) {
+	if z.off >= z.size {
+		return 0, io.EOF
+	}
+	n, err := z.ReadAt(p, z.off)
+	z.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read, like io.Seeker.
+func (z *ReaderAt) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += z.off
+	case io.SeekEnd:
+		offset += z.size
+	default:
+		return 0, errors.New("reductor: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("reductor: negative position")
+	}
+	z.off = offset
+	return offset, nil
+}
@@ -0,0 +1,133 @@
+package reductor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func Test_ReaderAtReadAt(t *testing.T) {
+	input := make([]byte, 50000)
+	for i := range input {
+		input[i] = byte(i * i >> 8)
+	}
+	compressed := indexedStream(t, input, 7000, ChecksumCRC32C)
+	zr, err := NewReaderAt(bytes.NewReader(compressed), int64(len(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.Size() != int64(len(input)) {
+		t.Fatalf("unexpected size (got %d want %d)", zr.Size(), len(input))
+	}
+	var tests = []struct {
+		name    string
+		off     int64
+		n       int
+		wantN   int
+		wantErr error
+	}{
+		{name: "Within a block", off: 100, n: 1000, wantN: 1000},
+		{name: "Across blocks", off: 6000, n: 16000, wantN: 16000},
+		{name: "Up to the end", off: 49000, n: 1000, wantN: 1000},
+		{name: "Past the end", off: 49000, n: 2000, wantN: 1000, wantErr: io.EOF},
+		{name: "After the end", off: 60000, n: 10, wantErr: io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := make([]byte, tt.n)
+			n, err := zr.ReadAt(p, tt.off)
+			if n != tt.wantN || err != tt.wantErr {
+				t.Fatalf("unexpected result (got %d, %v want %d, %v)", n, err, tt.wantN, tt.wantErr)
+			}
+			if n > 0 && !bytes.Equal(p[:n], input[tt.off:tt.off+int64(n)]) {
+				t.Error("unexpected content")
+			}
+		})
+	}
+}
+
+func Test_ReaderAtConcurrent(t *testing.T) {
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 1000)
+	compressed := indexedStream(t, input, 5000, ChecksumCRC32C)
+	zr, err := NewReaderAt(bytes.NewReader(compressed), int64(len(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			p := make([]byte, 777)
+			for off := int64(g * 100); off+int64(len(p)) <= int64(len(input)); off += 3000 {
+				if _, err := zr.ReadAt(p, off); err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(p, input[off:off+int64(len(p))]) {
+					t.Errorf("unexpected content at %d", off)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func Test_ReaderAtSeek(t *testing.T) {
+	input := bytes.Repeat([]byte("0123456789"), 2000)
+	compressed := indexedStream(t, input, 3000, ChecksumCRC32C)
+	zr, err := NewReaderAt(bytes.NewReader(compressed), int64(len(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name   string
+		offset int64
+		whence int
+		want   int64
+	}{
+		{name: "Start", offset: 2500, whence: io.SeekStart, want: 2500},
+		{name: "Current", offset: 1000, whence: io.SeekCurrent, want: 3500},
+		{name: "End", offset: -5, whence: io.SeekEnd, want: int64(len(input)) - 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, err := zr.Seek(tt.offset, tt.whence)
+			if err != nil || pos != tt.want {
+				t.Fatalf("unexpected position (got %d, %v want %d)", pos, err, tt.want)
+			}
+			// Read some, then return to the position.
+			p := make([]byte, 5)
+			if _, err := io.ReadFull(zr, p); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(p, input[pos:pos+5]) {
+				t.Errorf("unexpected content (got %q want %q)", p, input[pos:pos+5])
+			}
+			zr.Seek(pos, io.SeekStart)
+		})
+	}
+	// Reading from the start returns all of the content.
+	zr.Seek(0, io.SeekStart)
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, input) {
+		t.Errorf("roundtrip mismatch (got %d bytes want %d bytes)", len(got), len(input))
+	}
+}
+
+func Test_NewReaderAtWithoutIndex(t *testing.T) {
+	var compressed bytes.Buffer
+	if err := Compress(&compressed, bytes.NewReader([]byte("abcd")), Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewReaderAt(bytes.NewReader(compressed.Bytes()), int64(compressed.Len())); !errors.Is(err, ErrNoIndex) {
+		t.Errorf("unexpected error (got %v want %v)", err, ErrNoIndex)
+	}
+}
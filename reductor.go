@@ -0,0 +1,269 @@
+// Package reductor implements a lossless compressor combining LZ77 and
+// Huffman coding.
+//
+// Input is split into blocks. Each block is converted to a sequence of
+// literals and LZ77 pointers (see BytesToValues), which is then encoded
+// with Huffman codes built for that block: one for literals and pointer
+// lengths, and one for pointer distances. Pointers may refer to data
+// from previous blocks, so Writer and Reader only keep the current block
+// and up to twice the search window in memory.
+//
+// Compressed output is deterministic: the same input and Options always
+// produce the same bytes, regardless of how input is split between writes.
+package reductor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ErrCorrupt is returned when compressed data is malformed.
+var ErrCorrupt = errors.New("reductor: corrupt input")
+
+// Options configure compression. The zero value is valid and selects
+// the defaults.
+type Options struct {
+	// Level, if set, selects a compression level between MinLevel and
+	// MaxLevel, or LevelUltra. Its preset fills in MatchFinder, Parser,
+	// MaxMatch, SearchSize and ChainDepth where they are left unset, so
+	// fields set explicitly override it.
+	Level int
+	// MinMatch is the minimum length of an LZ match. Defaults to 4.
+	MinMatch uint8
+	// MaxMatch is the maximum length of an LZ match. Defaults to 255, or
+	// the preset of Level.
+	MaxMatch uint16
+	// SearchSize is the size of the LZ search window, up to MaxSearchSize.
+	// Both Writer and Reader keep up to twice as much data in memory, and
+	// match finders take another 4 (hash chain) or 8 (binary tree) bytes
+	// for every byte of it. Defaults to 4096, or the preset of Level.
+	SearchSize uint32
+	// ChainDepth is the number of earlier positions checked when looking
+	// for a match. Larger values find longer matches, but take more time.
+	// Defaults to 128, or the preset of Level.
+	ChainDepth uint16
+	// MatchFinder selects the algorithm finding LZ matches. Defaults to
+	// MatchFinderHashChain, or the preset of Level.
+	MatchFinder MatchFinder
+	// Parser selects how matches are chosen. Defaults to ParserLazy, or
+	// the preset of Level.
+	Parser Parser
+	// LongWindow, if set, enables long-distance matching: before regular
+	// matching, repeats of at least 64 bytes up to LongWindow bytes apart
+	// are found by fingerprinting the input with a rolling hash. It is meant
+	// for large inputs with repetition far beyond SearchSize, like backups
+	// holding duplicated files. Like SearchSize, up to twice as much data
+	// is kept in memory. It must not exceed MaxLongWindow.
+	LongWindow uint32
+	// MaxCodeLength limits the length of Huffman codes, so that decoders
+	// can use fixed size lookup tables. It must be between MinCodeLengthLimit
+	// and MaxCodeLengthLimit. Defaults to 15.
+	MaxCodeLength uint8
+	// ContentSize, if positive, is the size of the input. It is recorded
+	// in the stream header and verified by both Writer and Reader.
+	// Compress sets it automatically when src holds its data in memory,
+	// like bytes.Reader, as the size of a file may change while it is read.
+	ContentSize int64
+	// Checksum selects the algorithm used to verify the uncompressed
+	// content. Defaults to ChecksumCRC32C.
+	Checksum Checksum
+	// HeaderChecksum adds a checksum of the stream header.
+	HeaderChecksum bool
+	// Concurrency, if positive, is the number of blocks of input compressed
+	// in parallel. Each block then gets a match finder of its own and its
+	// matches do not reach into earlier blocks, unless PrimeWindow is set.
+	// The output is the same for any positive Concurrency, but differs
+	// from the output with zero, which compresses on the calling goroutine.
+	Concurrency int
+	// PrimeWindow makes blocks compressed in parallel find matches in the
+	// search window of input preceding them, like blocks compressed on a
+	// single goroutine do. It improves the ratio, but blocks no longer
+	// decompress independently.
+	PrimeWindow bool
+	// Index appends an index of blocks to the stream, which lets
+	// DecompressParallel decode them in parallel. It requires Concurrency,
+	// without PrimeWindow, so that blocks decompress independently.
+	Index bool
+	// Dictionary, if set, primes the search window, as if it preceded the
+	// input, so that even small inputs find matches in it. Its end, up to
+	// the window size, is used. The stream records the DictionaryID of it
+	// and can only be decompressed with NewReaderDict given the same
+	// dictionary. Blocks compressed in parallel find matches in it only
+	// with PrimeWindow, so it cannot be combined with Index.
+	Dictionary []byte
+
+	// Graphviz, if set, receives the Huffman tree of every block in DOT
+	// format.
+	Graphviz io.Writer
+	// LZ, if set, receives a textual LZ representation of the input,
+	// with pointers written as <distance,length>.
+	LZ io.Writer
+}
+
+const (
+	DefaultMinMatch      = 4
+	DefaultMaxMatch      = 255
+	DefaultSearchSize    = 4096
+	DefaultChainDepth    = 128
+	DefaultMaxCodeLength = 15
+
+	// MaxSearchSize is the largest allowed SearchSize.
+	MaxSearchSize = 1 << 29
+	// MaxLongWindow is the largest allowed LongWindow, which is the largest
+	// distance the format can encode.
+	MaxLongWindow = maxDistance
+
+	// MinCodeLengthLimit is the smallest MaxCodeLength that fits every
+	// symbol of the largest alphabet, the literal/length one, which holds
+	// more than the 256 byte values.
+	MinCodeLengthLimit = 9
+	// MaxCodeLengthLimit is the largest allowed MaxCodeLength.
+	MaxCodeLengthLimit = 32
+)
+
+// withDefaults returns a copy of opts with unset fields filled in.
+func (opts Options) withDefaults() Options {
+	opts = opts.withLevel()
+	if opts.MatchFinder == MatchFinderDefault {
+		opts.MatchFinder = MatchFinderHashChain
+	}
+	if opts.Parser == ParserDefault {
+		opts.Parser = ParserLazy
+	}
+	if opts.MinMatch == 0 {
+		opts.MinMatch = DefaultMinMatch
+	}
+	if opts.MaxMatch == 0 {
+		opts.MaxMatch = DefaultMaxMatch
+	}
+	if opts.SearchSize == 0 {
+		opts.SearchSize = DefaultSearchSize
+	}
+	if opts.ChainDepth == 0 {
+		opts.ChainDepth = DefaultChainDepth
+	}
+	if opts.MaxCodeLength == 0 {
+		opts.MaxCodeLength = DefaultMaxCodeLength
+	}
+	if opts.Graphviz == nil {
+		opts.Graphviz = ioutil.Discard
+	}
+	if opts.LZ == nil {
+		opts.LZ = ioutil.Discard
+	}
+	return opts
+}
+
+// window returns the largest distance of a match.
+func (opts Options) window() uint32 {
+	if opts.LongWindow > opts.SearchSize {
+		return opts.LongWindow
+	}
+	return opts.SearchSize
+}
+
+func (opts Options) validate() error {
+	if opts.Level != 0 && (opts.Level < MinLevel || opts.Level > LevelUltra) {
+		return fmt.Errorf("reductor: level %d out of range [%d, %d]", opts.Level, MinLevel, LevelUltra)
+	}
+	if opts.ContentSize < 0 {
+		return fmt.Errorf("reductor: negative content size %d", opts.ContentSize)
+	}
+	if opts.Concurrency < 0 {
+		return fmt.Errorf("reductor: negative concurrency %d", opts.Concurrency)
+	}
+	if opts.Index && (opts.Concurrency == 0 || opts.PrimeWindow) {
+		return errors.New("reductor: an index requires concurrency without a primed window")
+	}
+	if opts.Index && len(opts.Dictionary) > 0 {
+		return errors.New("reductor: an index cannot be combined with a dictionary")
+	}
+	if !opts.MatchFinder.valid() {
+		return fmt.Errorf("reductor: unknown match finder %d", opts.MatchFinder)
+	}
+	if !opts.Parser.valid() {
+		return fmt.Errorf("reductor: unknown parser %d", opts.Parser)
+	}
+	if !opts.Checksum.valid() {
+		return fmt.Errorf("reductor: unknown checksum %d", opts.Checksum)
+	}
+	if opts.MaxCodeLength < MinCodeLengthLimit || opts.MaxCodeLength > MaxCodeLengthLimit {
+		return fmt.Errorf("reductor: max code length %d out of range [%d, %d]", opts.MaxCodeLength, MinCodeLengthLimit, MaxCodeLengthLimit)
+	}
+	if opts.SearchSize > MaxSearchSize {
+		return fmt.Errorf("reductor: search size %d larger than %d", opts.SearchSize, MaxSearchSize)
+	}
+	if opts.LongWindow > MaxLongWindow {
+		return fmt.Errorf("reductor: long window %d larger than %d", opts.LongWindow, MaxLongWindow)
+	}
+	if uint16(opts.MinMatch) > opts.MaxMatch {
+		return fmt.Errorf("reductor: min-match %d is larger than max-match %d", opts.MinMatch, opts.MaxMatch)
+	}
+	return nil
+}
+
+// Compress reads src until EOF and writes its compressed representation
+// to dst.
+func Compress(dst io.Writer, src io.Reader, opts Options) error {
+	if opts.ContentSize == 0 {
+		opts.ContentSize = sizeOf(src)
+	}
+	zw, err := NewWriter(dst, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(zw, src); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Decompress reads data produced by Compress from src and writes the
+// original content to dst.
+func Decompress(dst io.Writer, src io.Reader) error {
+	return DecompressDict(dst, src, nil)
+}
+
+// DecompressDict is like Decompress for data compressed with
+// Options.Dictionary set to dict.
+func DecompressDict(dst io.Writer, src io.Reader, dict []byte) error {
+	zr, err := NewReaderDict(src, dict)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, zr)
+	return err
+}
+
+// sizeOf returns the amount of data left in r, or 0 if it is unknown or
+// may change before r is drained.
+func sizeOf(r io.Reader) int64 {
+	if r, ok := r.(interface{ Len() int }); ok {
+		return int64(r.Len())
+	}
+	return 0
+}
+
+// checkValues verifies that every pointer starts in already decoded data,
+// given histSize bytes of history, and within window bytes, so that
+// appendValues can safely be called on values. It also verifies that
+// values decode to at most maxSize bytes, which bounds the memory taken
+// by a block.
+func checkValues(values []Value, histSize, window, maxSize int) error {
+	size := histSize
+	for _, v := range values {
+		if v.IsLiteral {
+			size++
+		} else if v.distance == 0 || int(v.distance) > size || int(v.distance) > window {
+			return ErrCorrupt
+		} else {
+			size += int(v.length)
+		}
+		if size-histSize > maxSize {
+			return ErrCorrupt
+		}
+	}
+	return nil
+}
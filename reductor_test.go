@@ -0,0 +1,291 @@
+package reductor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_CompressDecompress(t *testing.T) {
+	random := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(random)
+	var tests = []struct {
+		name  string
+		input []byte
+		opts  Options
+	}{
+		{
+			name:  "Empty input",
+			input: []byte(""),
+		},
+		{
+			name:  "Text",
+			input: bytes.Repeat([]byte("A lossless compressor combining LZ and Huffman Coding. "), 100),
+		},
+		{
+			name:  "Random bytes",
+			input: random,
+		},
+		{
+			name:  "Custom options",
+			input: bytes.Repeat([]byte("abcabcabd"), 1000),
+			opts:  Options{MinMatch: 3, MaxMatch: 10, SearchSize: 16},
+		},
+		{
+			name:  "Records",
+			input: recordData(1000),
+			opts:  Options{MinMatch: 3, Parser: ParserOptimal},
+		},
+		{
+			name:  "Binary tree match finder",
+			input: append(bytes.Repeat([]byte("a"), 1000), bytes.Repeat([]byte("abcabcabd"), 1000)...),
+			opts:  Options{MatchFinder: MatchFinderBinaryTree, SearchSize: 65535},
+		},
+		{
+			name:  "Two-step lazy with single byte matches",
+			input: random,
+			opts:  Options{MinMatch: 1, Parser: ParserLazy2},
+		},
+		{
+			name:  "Two-step lazy with single byte matches and binary tree",
+			input: random,
+			opts:  Options{MinMatch: 1, Parser: ParserLazy2, MatchFinder: MatchFinderBinaryTree},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var compressed, got bytes.Buffer
+			if err := Compress(&compressed, bytes.NewReader(tt.input), tt.opts); err != nil {
+				t.Fatalf("compress: %v", err)
+			}
+			if err := Decompress(&got, &compressed); err != nil {
+				t.Fatalf("decompress: %v", err)
+			}
+			if !bytes.Equal(got.Bytes(), tt.input) {
+				t.Errorf("roundtrip mismatch (got %d bytes want %d bytes)", got.Len(), len(tt.input))
+			}
+		})
+	}
+}
+
+func Test_CompressSingleByteMatches(t *testing.T) {
+	// With single byte matches, parsers look up matches at nearly every
+	// position, also ahead of the one being parsed, and matches often
+	// overlap the data they produce.
+	rng := rand.New(rand.NewSource(3))
+	var inputs [][]byte
+	for _, alphabet := range []int{2, 16, 256} {
+		input := make([]byte, 5000)
+		for i := range input {
+			input[i] = byte(rng.Intn(alphabet))
+		}
+		inputs = append(inputs, input)
+	}
+	for _, parser := range []Parser{ParserGreedy, ParserLazy, ParserLazy2, ParserOptimal} {
+		for _, kind := range []MatchFinder{MatchFinderHashChain, MatchFinderBinaryTree} {
+			t.Run(fmt.Sprintf("%v/%v", parser, kind), func(t *testing.T) {
+				for _, input := range inputs {
+					var compressed, got bytes.Buffer
+					if err := Compress(&compressed, bytes.NewReader(input), Options{MinMatch: 1, Parser: parser, MatchFinder: kind}); err != nil {
+						t.Fatalf("compress: %v", err)
+					}
+					if err := Decompress(&got, &compressed); err != nil {
+						t.Fatalf("decompress: %v", err)
+					}
+					if !bytes.Equal(got.Bytes(), input) {
+						t.Fatalf("roundtrip mismatch (got %d bytes want %d bytes)", got.Len(), len(input))
+					}
+				}
+			})
+		}
+	}
+}
+
+func Test_CompressGrowingFile(t *testing.T) {
+	// Data appended to a file while it is compressed is compressed too.
+	rng := rand.New(rand.NewSource(5))
+	input := make([]byte, 2*blockSize+1000)
+	rng.Read(input)
+	path := filepath.Join(t.TempDir(), "input")
+	if err := ioutil.WriteFile(path, input[:2*blockSize], 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var compressed bytes.Buffer
+	dst := &appendOnWrite{w: &compressed, path: path, data: input[2*blockSize:]}
+	if err := Compress(dst, f, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if dst.data != nil {
+		t.Fatal("compressed data was written only once the input was read")
+	}
+	var got bytes.Buffer
+	if err := Decompress(&got, &compressed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), input) {
+		t.Errorf("roundtrip mismatch (got %d bytes want %d bytes)", got.Len(), len(input))
+	}
+}
+
+// appendOnWrite appends data to the file at path on the first write.
+type appendOnWrite struct {
+	w    io.Writer
+	path string
+	data []byte
+}
+
+func (a *appendOnWrite) Write(p []byte) (int, error) {
+	if a.data != nil {
+		f, err := os.OpenFile(a.path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		if _, err := f.Write(a.data); err != nil {
+			return 0, err
+		}
+		a.data = nil
+	}
+	return a.w.Write(p)
+}
+
+func Test_CompressInvalidOptions(t *testing.T) {
+	var tests = []struct {
+		name string
+		opts Options
+	}{
+		{name: "Min-match larger than max-match", opts: Options{MinMatch: 10, MaxMatch: 5}},
+		{name: "Negative concurrency", opts: Options{Concurrency: -1}},
+		{name: "Level out of range", opts: Options{Level: LevelUltra + 1}},
+		{name: "Negative level", opts: Options{Level: -1}},
+		{name: "Index without concurrency", opts: Options{Index: true}},
+		{name: "Index with primed window", opts: Options{Concurrency: 2, PrimeWindow: true, Index: true}},
+		{name: "Index with dictionary", opts: Options{Concurrency: 2, Index: true, Dictionary: []byte("dictionary")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Compress(&bytes.Buffer{}, bytes.NewReader(nil), tt.opts); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// determinismCorpus returns inputs of different kinds used to check that
+// output does not change between runs.
+func determinismCorpus() map[string][]byte {
+	random := make([]byte, 50000)
+	rand.New(rand.NewSource(7)).Read(random)
+	// Many byte values with equal frequencies exercise tie-breaking.
+	ties := make([]byte, 0, 256*16)
+	for i := 0; i < 16; i++ {
+		for b := 0; b < 256; b++ {
+			ties = append(ties, byte(b*(i+1)))
+		}
+	}
+	return map[string][]byte{
+		"text":   bytes.Repeat([]byte("Our build caches need byte-identical output. "), 2000),
+		"random": random,
+		"ties":   ties,
+	}
+}
+
+func Test_CompressDeterministic(t *testing.T) {
+	for name, input := range determinismCorpus() {
+		t.Run(name, func(t *testing.T) {
+			var want [sha256.Size]byte
+			for run := 0; run < 5; run++ {
+				var compressed bytes.Buffer
+				zw, err := NewWriter(&compressed, Options{ContentSize: int64(len(input))})
+				if err != nil {
+					t.Fatal(err)
+				}
+				zw.blockSize = 20000
+				// Vary how input is split between writes.
+				chunk := 1000*run + 1
+				for p := input; len(p) > 0; p = p[min(len(p), chunk):] {
+					if _, err := zw.Write(p[:min(len(p), chunk)]); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := zw.Close(); err != nil {
+					t.Fatal(err)
+				}
+				got := sha256.Sum256(compressed.Bytes())
+				if run == 0 {
+					want = got
+				} else if got != want {
+					t.Fatalf("run %d produced different output (got %x want %x)", run, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Benchmark_Decompress(b *testing.B) {
+	input := determinismCorpus()["text"]
+	var compressed bytes.Buffer
+	if err := Compress(&compressed, bytes.NewReader(input), Options{}); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Decompress(ioutil.Discard, bytes.NewReader(compressed.Bytes())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkText returns text made of words with skewed frequencies.
+func benchmarkText(n int) []byte {
+	rng := rand.New(rand.NewSource(5))
+	zipf := rand.NewZipf(rng, 1.1, 1, 2000)
+	words := make([][]byte, 2001)
+	for i := range words {
+		word := make([]byte, 2+rng.Intn(8))
+		for j := range word {
+			word[j] = byte('a' + rng.Intn(26))
+		}
+		words[i] = word
+	}
+	var text []byte
+	for len(text) < n {
+		text = append(text, words[zipf.Uint64()]...)
+		text = append(text, " .,\n"[rng.Intn(4)])
+	}
+	return text[:n]
+}
+
+// Benchmark_Compress compares match finders. Besides speed, it reports
+// the compression ratio achieved.
+func Benchmark_Compress(b *testing.B) {
+	input := benchmarkText(1 << 20)
+	for _, kind := range []MatchFinder{MatchFinderHashChain, MatchFinderBinaryTree} {
+		for _, window := range []uint32{DefaultSearchSize, 65535} {
+			b.Run(fmt.Sprintf("%v/%d", kind, window), func(b *testing.B) {
+				opts := Options{MatchFinder: kind, SearchSize: window}
+				var compressed bytes.Buffer
+				b.SetBytes(int64(len(input)))
+				for i := 0; i < b.N; i++ {
+					compressed.Reset()
+					if err := Compress(&compressed, bytes.NewReader(input), opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(input))/float64(compressed.Len()), "ratio")
+			})
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package reductor
+
+import (
+	"io/ioutil"
+	"math"
+)
+
+// Blocks of input are split further where the statistics of values change,
+// e.g. between text and binary sections, so that each part gets a code of
+// its own. The values are cut into chunks of splitChunk values, and
+// neighbouring chunks are merged as long as coding them together is not
+// more expensive than coding them separately, including the table.
+const splitChunk = 4096
+
+// splitValues splits values into parts to be coded as separate blocks.
+func splitValues(values []Value, limit int) [][]Value {
+	var (
+		parts [][]Value
+		// values[start:end] is the part being merged.
+		start, end   int
+		litLen, dist []int
+		bits         int
+	)
+	for end < len(values) {
+		next := min(end+splitChunk, len(values))
+		chunkLitLen, chunkDist := countFreqs(values[end:next])
+		chunkBits := blockBits(chunkLitLen, chunkDist, limit)
+		if end > start {
+			mergedLitLen, mergedDist := addFreqs(litLen, chunkLitLen), addFreqs(dist, chunkDist)
+			if merged := blockBits(mergedLitLen, mergedDist, limit); merged <= bits+chunkBits {
+				litLen, dist, bits, end = mergedLitLen, mergedDist, merged, next
+				continue
+			}
+			parts = append(parts, values[start:end])
+		}
+		start, end = end, next
+		litLen, dist, bits = chunkLitLen, chunkDist, chunkBits
+	}
+	if end > start {
+		parts = append(parts, values[start:end])
+	}
+	return parts
+}
+
+// addFreqs returns the sums of frequencies in a and b.
+func addFreqs(a, b []int) []int {
+	sum := make([]int, len(a))
+	for i := range a {
+		sum[i] = a[i] + b[i]
+	}
+	return sum
+}
+
+// blockBits estimates the size of a block with symbol frequencies litLen
+// and dist in bits, coded with a new table. Building Huffman codes for
+// every candidate would be slow, so symbols are priced by their entropy.
+// Extra bits are left out, as they do not depend on the code.
+func blockBits(litLen, dist []int, limit int) int {
+	litLenBits, litLenLengths := entropyBits(litLen, limit)
+	distBits, distLengths := entropyBits(dist, limit)
+	return int(litLenBits+distBits) + tableBits(litLenLengths, distLengths)
+}
+
+// entropyBits returns the entropy of symbols with frequencies freqs in
+// bits, and code lengths close to those a Huffman code would have.
+func entropyBits(freqs []int, limit int) (float64, []byte) {
+	total := 0
+	for _, f := range freqs {
+		total += f
+	}
+	bits := 0.0
+	lengths := make([]byte, len(freqs))
+	for sym, f := range freqs {
+		if f == 0 {
+			continue
+		}
+		l := math.Log2(float64(total) / float64(f))
+		bits += float64(f) * l
+		lengths[sym] = byte(min(max(1, int(l+0.5)), limit))
+	}
+	return bits, lengths
+}
+
+// codedBits returns the number of bits symbols with frequencies litLen and
+// dist take with the given code lengths. It returns false if a symbol has
+// no code.
+func codedBits(litLen, dist []int, litLenLengths, distLengths []byte) (int, bool) {
+	bits := 0
+	for _, c := range []struct {
+		freqs   []int
+		lengths []byte
+	}{{litLen, litLenLengths}, {dist, distLengths}} {
+		for sym, f := range c.freqs {
+			if f == 0 {
+				continue
+			}
+			if sym >= len(c.lengths) || c.lengths[sym] == 0 {
+				return math.MaxInt32, false
+			}
+			bits += f * int(c.lengths[sym])
+		}
+	}
+	return bits, true
+}
+
+// tableBits returns the number of bits the code lengths take in a block.
+func tableBits(litLen, dist []byte) int {
+	bw := newBinaryWriter(ioutil.Discard)
+	bw.lengths = append(append(bw.lengths, litLen...), dist...)
+	bw.writeCodeLengths()
+	return int(bw.w.BitsCount)
+}
+
+// valuesLen returns the number of bytes values decode to.
+func valuesLen(values []Value) int {
+	n := 0
+	for _, v := range values {
+		if v.IsLiteral {
+			n++
+		} else {
+			n += int(v.length)
+		}
+	}
+	return n
+}
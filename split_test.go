@@ -0,0 +1,90 @@
+package reductor
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func Test_splitValues(t *testing.T) {
+	text := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 1000)
+	binary := make([]byte, 40000)
+	rng := rand.New(rand.NewSource(1))
+	// Bytes far from the text alphabet, with a skewed distribution.
+	for i := range binary {
+		binary[i] = byte(128 + rng.Intn(16)*rng.Intn(8))
+	}
+	literals := func(input []byte) []Value {
+		values := make([]Value, len(input))
+		for i, b := range input {
+			values[i] = NewValue(true, b, 1, 0)
+		}
+		return values
+	}
+	var tests = []struct {
+		name   string
+		values []Value
+		want   []int
+	}{
+		{name: "Empty"},
+		{name: "Uniform", values: literals(text), want: []int{len(text)}},
+		{
+			name:   "Text then binary",
+			values: literals(append(append([]byte{}, text[:4*splitChunk]...), binary[:3*splitChunk]...)),
+			want:   []int{4 * splitChunk, 3 * splitChunk},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := splitValues(tt.values, DefaultMaxCodeLength)
+			var got []int
+			for _, part := range parts {
+				got = append(got, len(part))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("unexpected parts (got %v want %v)", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("unexpected parts (got %v want %v)", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_WriterReusesCodes(t *testing.T) {
+	// Copies of random letters, coded as literals only, so that every
+	// block has the same statistics.
+	block := make([]byte, 10000)
+	rng := rand.New(rand.NewSource(2))
+	for i := range block {
+		block[i] = "etaoin shrdlu"[rng.Intn(13)]
+	}
+	input := bytes.Repeat(block, 10)
+	var compressed, graphviz bytes.Buffer
+	zw, err := NewWriter(&compressed, Options{MinMatch: 255, Graphviz: &graphviz})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the first block needs codes.
+	zw.blockSize = len(block)
+	if _, err := zw.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Every new table dumps two trees.
+	if trees := strings.Count(graphviz.String(), "Digraph"); trees != 2 {
+		t.Errorf("unexpected number of tables (got %d want 1)", trees/2)
+	}
+	var got bytes.Buffer
+	if err := Decompress(&got, &compressed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), input) {
+		t.Errorf("roundtrip mismatch (got %d bytes want %d bytes)", got.Len(), len(input))
+	}
+}
@@ -1,9 +1,4 @@
-package main
-
-import (
-	"log"
-	"os"
-)
+package reductor
 
 func min(a, b int) int {
 	if a < b {
@@ -18,16 +13,3 @@ func max(a, b int) int {
 	}
 	return a
 }
-
-func getFileSize(filePath string) int64 {
-	f, err := os.Open(filePath)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer f.Close()
-	fi, err := f.Stat()
-	if err != nil {
-		log.Fatal(err)
-	}
-	return fi.Size()
-}
@@ -1,10 +1,8 @@
-package main
+package reductor
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
-	"log"
 )
 
 // Value is a LZ77 sequence element - a literal or a pointer.
@@ -15,11 +13,11 @@ type Value struct {
 	val byte
 
 	// Pointer
-	distance uint16
-	length   byte
+	distance uint32
+	length   uint16
 }
 
-func NewValue(isLiteral bool, value, length byte, distance uint16) Value {
+func NewValue(isLiteral bool, value byte, length uint16, distance uint32) Value {
 	return Value{
 		IsLiteral: isLiteral,
 		val:       value,
@@ -40,128 +38,168 @@ func (v *Value) GetLiteralBinary() byte {
 	return v.val
 }
 
-// GetPointerBinary returns binary representation of pointer.
-// A pointer is serialized to a few bytes, so that there are less possible
-// nodes in a Huffman tree.
+// GetPointerBinary returns binary representation of pointer used by the
+// headerless legacy format. It only fits distances up to 65535 and lengths
+// up to 255.
 func (v *Value) GetPointerBinary() []byte {
 	bytes := make([]byte, 3)
 	// First 2 bytes encode a distance.
-	binary.BigEndian.PutUint16(bytes, v.distance)
+	binary.BigEndian.PutUint16(bytes, uint16(v.distance))
 	// The last byte is length.
-	bytes[2] = v.length
+	bytes[2] = byte(v.length)
 	return bytes
 }
 
 // BytesToValues converts input to []Value, by replacing series of
-// characters with LZ77 pointers wherever possible.
-func BytesToValues(input []byte, minMatchLen, maxMatchLen byte, maxSearchBuffLen uint16) []Value {
-	var (
-		searchBuffStart, lookaheadBuffEnd, p int
-		dist                                 uint16
-		l                                    byte
-	)
-
-	values := make([]Value, len(input)) // Almost always it will be less, but lets over-allocate.
-	value_counter := 0
-	pointer_counter := 0
-	for split := 0; split < len(input); split += 1 {
-		searchBuffStart = max(0, split-int(maxSearchBuffLen))
-		lookaheadBuffEnd = min(len(input), split+int(maxMatchLen))
-
-		p, l = getLongestMatchPosAndLen(input[searchBuffStart:split], input[split:lookaheadBuffEnd], minMatchLen)
-
-		if split > int(minMatchLen) && l > 0 {
-			// p is a position within searchBuff, so we need to calculate distance from the split.
-			dist = uint16(split - (p + searchBuffStart))
-			values[value_counter] = NewValue(false, 0, l, dist)
-			value_counter += 1
-			split += (int(l) - 1)
-			pointer_counter += 1
-		} else {
-			values[value_counter] = NewValue(true, input[split], 1, 0)
-			value_counter += 1
-		}
-	}
-	log.Printf("Pointers ratio: %.2f\n", float64(pointer_counter)/float64(value_counter))
-	return values[:value_counter]
+// characters with LZ77 pointers wherever possible. It greedily takes the
+// longest match found among up to DefaultChainDepth candidates.
+func BytesToValues(input []byte, minMatchLen uint8, maxMatchLen uint16, maxSearchBuffLen uint32) []Value {
+	return BytesToValuesDict(input, nil, minMatchLen, maxMatchLen, maxSearchBuffLen)
 }
 
-func getLongestMatchPosAndLen(text, pattern []byte, minMatchLen byte) (int, byte) {
-	if len(pattern) < int(minMatchLen) {
-		return 0, 0
+// BytesToValuesDict is like BytesToValues, but pointers may also refer to
+// the end of dict, as if it preceded input. ValuesToBytesDict decodes the
+// values given the same dict.
+func BytesToValuesDict(input, dict []byte, minMatchLen uint8, maxMatchLen uint16, maxSearchBuffLen uint32) []Value {
+	opts := Options{
+		MinMatch:   minMatchLen,
+		MaxMatch:   maxMatchLen,
+		SearchSize: maxSearchBuffLen,
+		ChainDepth: DefaultChainDepth,
+		Parser:     ParserGreedy,
+	}
+	dict = dictionaryTail(dict, int(maxSearchBuffLen))
+	if len(dict) > 0 {
+		input = append(append(make([]byte, 0, len(dict)+len(input)), dict...), input...)
 	}
-	var (
-		matchLen, maxSoFar, length byte
-		position                   int
-	)
-	// Heuristic: get indexes at which at least minMatchLen of pattern matches.
-	minMatchStarts := getMatchIndex(text, pattern[:minMatchLen])
-	for _, matchStart := range minMatchStarts {
-		matchLen = getMatchLen(text[matchStart:], pattern)
-		if matchLen >= minMatchLen && matchLen > maxSoFar {
-			position = matchStart
-			length, maxSoFar = matchLen, matchLen
+	mf := newMatchFinder(opts.MatchFinder, input, int(minMatchLen), int(maxMatchLen), int(maxSearchBuffLen), int(opts.ChainDepth))
+	return bytesToValues(mf, input, len(dict), opts, nil)
+}
+
+// bytesToValues converts input[start:] to []Value, finding matches with mf
+// and choosing them as configured by opts. Bytes before start are history -
+// they are not encoded, but pointers may refer to them. mf must have been
+// given input. If it was not asked about history yet, the history is
+// indexed first. Long-distance matches in long are taken as they are, the
+// input between them is parsed as usual. The values make up a block, so
+// repeat distances start afresh.
+func bytesToValues(mf matchFinder, input []byte, start int, opts Options, long []longMatch) []Value {
+	mc := matchCache{mf: mf, minMatch: int(opts.MinMatch)}
+	reps := newRepHistory()
+	values := make([]Value, 0, len(input)-start) // Almost always it will be less, but lets over-allocate.
+	for _, m := range long {
+		mc.end = m.pos
+		values = parseRange(values, &mc, &reps, input, start, opts)
+		for length := m.length; length > 0; {
+			l := min(length, int(opts.MaxMatch))
+			values = append(values, NewValue(false, 0, uint16(l), uint32(m.distance)))
+			reps.update(uint32(m.distance))
+			length -= l
 		}
+		start = m.pos + m.length
 	}
-	return position, length
+	mc.end = len(input)
+	return parseRange(values, &mc, &reps, input, start, opts)
 }
 
-// getMatchIndex will return a slice of indexes at which pattern begins.
-func getMatchIndex(text, pattern []byte) []int {
-	// We surely do not have any matches.
-	if len(text) == 0 || len(text) < len(pattern) {
-		return []int{}
+// parseRange appends values for input[start:mc.end] to values. reps holds
+// the repeat distances before the first of them and is kept up to date.
+func parseRange(values []Value, mc *matchCache, reps *repHistory, input []byte, start int, opts Options) []Value {
+	if opts.Parser == ParserOptimal {
+		return append(values, optimalParse(mc, reps, input, start, opts)...)
 	}
-	// If pattern is empty, then we have a match everywhere.
-	if len(pattern) == 0 {
-		matchIndicies := make([]int, len(text))
-		for i := range text {
-			matchIndicies[i] = i
+	lookahead := opts.Parser.lookahead()
+	for split := start; split < mc.end; {
+		m := mc.next(split)
+		// A repeat distance is cheap enough to be worth a byte of length.
+		end := min(mc.end, split+int(opts.MaxMatch))
+		if d, l := longestRep(input, split, end, reps, int(opts.window())); split > mc.minMatch && l >= max(1, int(opts.MinMatch)) && l+1 >= m.length {
+			m.position, m.length = split-int(d), l
+		}
+		if m.length == 0 {
+			values = append(values, NewValue(true, input[split], 1, 0))
+			split++
+			continue
+		}
+		// Starting k bytes later pays off if the match is longer by more
+		// than the k-1 extra literals.
+		skip := 0
+		for k := 1; k <= lookahead && split+k < mc.end && m.length < lazyMaxLen && m.length < int(opts.MaxMatch); k++ {
+			if next := mc.get(split + k); next.length > m.length+k-1 {
+				skip = k
+				break
+			}
+		}
+		if skip > 0 {
+			for _, b := range input[split : split+skip] {
+				values = append(values, NewValue(true, b, 1, 0))
+			}
+			split += skip
+			continue
 		}
-		return matchIndicies
+		distance := uint32(split - m.position)
+		values = append(values, NewValue(false, 0, uint16(m.length), distance))
+		reps.update(distance)
+		split += m.length
 	}
+	return values
+}
 
-	matchIndices := make([]int, 0)
-	for i := range text[:len(text)-len(pattern)+1] {
-		// Just look for where pattern matches. This is hot path, and this
-		// way to compare bytes turned out to be the fastest experimentally.
-		if text[i] == pattern[0] {
-			if text[i+1] == pattern[1] {
-				if text[i+2] == pattern[2] {
-					if bytes.Equal(text[i+3:i+len(pattern)], pattern[3:len(pattern)]) {
-						matchIndices = append(matchIndices, i)
-					}
-				}
-			}
+// longestRep returns the longest match for input[pos:end] at one of the
+// distances in reps, which reach no further than window.
+func longestRep(input []byte, pos, end int, reps *repHistory, window int) (distance uint32, length int) {
+	for _, d := range reps {
+		if int(d) > pos || int(d) > window {
+			continue
+		}
+		if l := getMatchLen(input[pos-int(d):], input[pos:end]); l > length {
+			distance, length = d, l
 		}
 	}
-	return matchIndices
+	return distance, length
 }
 
 // getMatchLen returns a length of a longest match between two sequences.
-func getMatchLen(a, b []byte) byte {
-	var matchLen byte
-	maxMatchLen := min(min(len(a), len(b)), 255)
-	for i := 0; i < maxMatchLen; i++ {
-		if a[i] == b[i] {
-			matchLen += 1
-		} else {
-			break
+func getMatchLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
 		}
 	}
-	return matchLen
+	return n
 }
 
 // ValuesToBytes converts data from value representation back to []byte representation.
 func ValuesToBytes(values []Value) []byte {
+	return appendValues(make([]byte, 0, len(values)), values) // We underallocate here.
+}
+
+// ValuesToBytesDict is like ValuesToBytes for values produced by
+// BytesToValuesDict with dict.
+func ValuesToBytesDict(values []Value, dict []byte) []byte {
+	bytes := appendValues(append(make([]byte, 0, len(dict)+len(values)), dict...), values)
+	return bytes[len(dict):]
+}
+
+// appendValues decodes values and appends the result to bytes. Pointers
+// may refer to data already present in bytes.
+func appendValues(bytes []byte, values []Value) []byte {
 	var from int
-	bytes := make([]byte, 0, len(values)) // We underallocate here.
 	for _, v := range values {
 		if v.IsLiteral {
 			bytes = append(bytes, v.val)
-		} else {
-			from = len(bytes) - int(v.distance)
+			continue
+		}
+		from = len(bytes) - int(v.distance)
+		if int(v.length) <= int(v.distance) {
 			bytes = append(bytes, bytes[from:from+int(v.length)]...)
+			continue
+		}
+		// The match overlaps the data it produces, so it has to be copied
+		// byte by byte, repeating the last distance bytes.
+		for i := 0; i < int(v.length); i++ {
+			bytes = append(bytes, bytes[from+i])
 		}
 	}
 	return bytes
@@ -1,19 +1,20 @@
-package main
+package reductor
 
 import (
+	"bytes"
 	"crypto/rand"
 	"fmt"
 	"testing"
 )
 
-func Test_getLongestMatchPosAndLen(t *testing.T) {
+func Test_longestMatch(t *testing.T) {
 	var tests = []struct {
 		name          string
 		searchBuff    []byte
 		lookaheadBuff []byte
 		wantPos       int
-		wantLen       byte
-		minMatchLen   byte
+		wantLen       int
+		minMatchLen   int
 	}{
 		{
 			name:          "Empty search buffer",
@@ -99,67 +100,19 @@ func Test_getLongestMatchPosAndLen(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p, l := getLongestMatchPosAndLen(tt.searchBuff, tt.lookaheadBuff, tt.minMatchLen)
-			if p != tt.wantPos {
-				t.Errorf("unexpected pos (got %d want %d)", p, tt.wantPos)
-			}
-			if l != 0 && l < tt.minMatchLen {
-				t.Errorf("found match shorter than expected (got len %d min len %d)", l, tt.minMatchLen)
-			}
-			if l != tt.wantLen {
-				t.Errorf("unexpected len (got %d want %d)", l, tt.wantLen)
-			}
-		})
-	}
-}
-
-func Test_getMatchIndex(t *testing.T) {
-	var tests = []struct {
-		name        string
-		text        []byte
-		pattern     []byte
-		wantMatches []int
-	}{
-		{
-			name:        "Match starts at the beginning",
-			text:        []byte("hello"),
-			pattern:     []byte("hel"),
-			wantMatches: []int{0},
-		},
-		{
-			name:        "Match starts somewher in the middle",
-			text:        []byte("abchello"),
-			pattern:     []byte("hel"),
-			wantMatches: []int{3},
-		},
-		{
-			name:        "Pattern is empty",
-			text:        []byte("abchello"),
-			pattern:     []byte(""),
-			wantMatches: []int{0, 1, 2, 3, 4, 5, 6, 7},
-		},
-		{
-			name:        "Text is empty",
-			text:        []byte(""),
-			pattern:     []byte("abc"),
-			wantMatches: []int{},
-		},
-		{
-			name:        "Several matches",
-			text:        []byte("aaaabcaaaabcaaaabc"),
-			pattern:     []byte("abc"),
-			wantMatches: []int{3, 9, 15},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			gotMatches := getMatchIndex(tt.text, tt.pattern)
-			if len(gotMatches) != len(tt.wantMatches) {
-				t.Errorf("unexpected min match starts (got '%v' want '%v')", gotMatches, tt.wantMatches)
-			}
-			for i := range gotMatches {
-				if gotMatches[i] != tt.wantMatches[i] {
-					t.Errorf("unexpected min match starts (got '%v' want '%v')", gotMatches, tt.wantMatches)
+			input := append(append([]byte{}, tt.searchBuff...), tt.lookaheadBuff...)
+			split := len(tt.searchBuff)
+			for _, kind := range []MatchFinder{MatchFinderHashChain, MatchFinderBinaryTree} {
+				mf := newMatchFinder(kind, input, tt.minMatchLen, 255, split, len(input))
+				p, l := mf.longestMatch(split)
+				if p != tt.wantPos {
+					t.Errorf("%v: unexpected pos (got %d want %d)", kind, p, tt.wantPos)
+				}
+				if l != 0 && l < tt.minMatchLen {
+					t.Errorf("%v: found match shorter than expected (got len %d min len %d)", kind, l, tt.minMatchLen)
+				}
+				if l != tt.wantLen {
+					t.Errorf("%v: unexpected len (got %d want %d)", kind, l, tt.wantLen)
 				}
 			}
 		})
@@ -171,8 +124,8 @@ func Test_bytesToValues(t *testing.T) {
 		name             string
 		input            []byte
 		minMatchLen      byte
-		maxMatchLen      byte
-		maxSearchBuffLen uint16
+		maxMatchLen      uint16
+		maxSearchBuffLen uint32
 		wantValuesRepr   string
 	}{
 		{
@@ -205,8 +158,9 @@ func Test_bytesToValues(t *testing.T) {
 			minMatchLen:      2,
 			maxMatchLen:      255,
 			maxSearchBuffLen: 255,
-			// The matches are the same length - first one is selected.
-			wantValuesRepr: "XXab<4,2>cd<8,2>",
+			// The matches are the same length - the one at the last
+			// distance is selected, as it is cheaper to encode.
+			wantValuesRepr: "XXab<4,2>cd<4,2>",
 		},
 		{
 			name:             "Three matches",
@@ -214,8 +168,9 @@ func Test_bytesToValues(t *testing.T) {
 			minMatchLen:      2,
 			maxMatchLen:      255,
 			maxSearchBuffLen: 255,
-			// The matches are the same length - first one is selected.
-			wantValuesRepr: "XXab<4,2>cd<8,2>ij<12,2>",
+			// The matches are the same length - the one at the last
+			// distance is selected, as it is cheaper to encode.
+			wantValuesRepr: "XXab<4,2>cd<4,2>ij<4,2>",
 		},
 		{
 			name:             "A match, almost too long",
@@ -301,6 +256,36 @@ func Test_ValuesToBytes(t *testing.T) {
 	}
 }
 
+func Test_appendValuesOverlapping(t *testing.T) {
+	values := []Value{
+		NewValue(true, 'a', 0, 0),
+		NewValue(true, 'b', 0, 0),
+		NewValue(false, 0, 5, 2),
+		NewValue(false, 0, 3, 1),
+	}
+	if got := string(ValuesToBytes(values)); got != "abababaaaa" {
+		t.Errorf("unexpected output (got %q want %q)", got, "abababaaaa")
+	}
+}
+
+func Test_bytesToValuesRun(t *testing.T) {
+	// After the first few literals, a run is encoded with overlapping
+	// matches, each as long as allowed.
+	input := make([]byte, 10000)
+	values := BytesToValues(input, DefaultMinMatch, DefaultMaxMatch, DefaultSearchSize)
+	if want := len(input)/DefaultMaxMatch + int(DefaultMinMatch) + 2; len(values) > want {
+		t.Errorf("too many values for a run (got %d want at most %d)", len(values), want)
+	}
+	for _, v := range values[DefaultMinMatch+1:] {
+		if v.IsLiteral || v.length != DefaultMaxMatch && v != values[len(values)-1] {
+			t.Fatalf("unexpected value %v", v)
+		}
+	}
+	if !bytes.Equal(ValuesToBytes(values), input) {
+		t.Errorf("roundtrip mismatch")
+	}
+}
+
 var Values []Value
 
 func Benchmark_ValuesToBytes(b *testing.B) {
@@ -0,0 +1,315 @@
+package reductor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+var errContentSize = errors.New("reductor: written data does not match Options.ContentSize")
+
+// blockSize is the amount of input encoded with a single Huffman table.
+const blockSize = 1 << 20
+
+// Writer is an io.WriteCloser that compresses data written to it.
+// Input is buffered and encoded in blocks, so only the current block and
+// up to twice the LZ search window are held in memory, plus the blocks
+// being compressed in parallel if Options.Concurrency is set.
+type Writer struct {
+	w    io.Writer
+	enc  *blockWriter
+	opts Options
+
+	// buf holds history (buf[:start]) followed by input pending encoding.
+	// mf indexes buf[mfBase:], which is only longer than needed for the
+	// search window when long-distance matching is enabled. lm, if set,
+	// indexes all of buf.
+	buf       []byte
+	start     int
+	mf        matchFinder
+	mfBase    int
+	lm        *longMatcher
+	blockSize int
+	// jobs are blocks compressed in parallel, in order, when
+	// opts.Concurrency is set.
+	jobs []*job
+	// written is the amount of compressed data written so far and index
+	// describes the blocks of input written by jobs.
+	written int64
+	index   []indexEntry
+	total   int64
+	// sum accumulates the content checksum, it is nil if disabled.
+	sum hash.Hash
+
+	wroteHeader bool
+	err         error
+	closed      bool
+}
+
+// NewWriter returns a Writer compressing data to w. It is the caller's
+// responsibility to call Close on the Writer when done.
+func NewWriter(w io.Writer, opts Options) (*Writer, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	z := &Writer{
+		w:         w,
+		enc:       newBlockWriter(opts),
+		opts:      opts,
+		blockSize: blockSize,
+		sum:       opts.Checksum.newHash(),
+	}
+	if opts.Concurrency == 0 {
+		z.mf = newMatchFinder(opts.MatchFinder, nil, int(opts.MinMatch), int(opts.MaxMatch), int(opts.SearchSize), int(opts.ChainDepth))
+	}
+	if opts.LongWindow > 0 {
+		z.lm = newLongMatcher(int(opts.LongWindow))
+	}
+	if len(opts.Dictionary) > 0 {
+		// The dictionary is history preceding the input. The long matcher
+		// indexes it right away, as its matches within it are of no use.
+		z.buf = append(z.buf, dictionaryTail(opts.Dictionary, int(opts.window()))...)
+		z.start = len(z.buf)
+		if z.lm != nil {
+			z.lm.find(z.buf, 0)
+		}
+	}
+	return z, nil
+}
+
+// Write compresses p. Compressed data may not be written to the
+// underlying writer until the current block fills up or Close is called.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("reductor: write to closed Writer")
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	n := len(p)
+	z.total += int64(n)
+	if z.opts.ContentSize > 0 && z.total > z.opts.ContentSize {
+		z.err = errContentSize
+		return 0, z.err
+	}
+	if z.sum != nil {
+		z.sum.Write(p)
+	}
+	for len(p) > 0 {
+		k := min(len(p), z.start+z.blockSize-len(z.buf))
+		z.buf = append(z.buf, p[:k]...)
+		p = p[k:]
+		if len(z.buf)-z.start == z.blockSize {
+			if z.err = z.writeBlock(); z.err != nil {
+				return n - len(p), z.err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close encodes any pending input. It does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err != nil {
+		return z.err
+	}
+	if z.opts.ContentSize > 0 && z.total != z.opts.ContentSize {
+		z.err = errContentSize
+		return z.err
+	}
+	if len(z.buf) > z.start {
+		if z.err = z.writeBlock(); z.err != nil {
+			return z.err
+		}
+	}
+	for len(z.jobs) > 0 {
+		if z.err = z.finishJob(); z.err != nil {
+			return z.err
+		}
+	}
+	if z.err = z.writeHeader(); z.err != nil {
+		return z.err
+	}
+	if z.err = z.enc.bw.writeEnd(); z.err != nil {
+		return z.err
+	}
+	// The trailer holds the content checksum.
+	if z.sum != nil {
+		z.enc.out.Write(z.sum.Sum(nil))
+	}
+	if z.opts.Index {
+		z.enc.out.Write(marshalIndex(z.index))
+	}
+	z.err = z.flush()
+	return z.err
+}
+
+// flush writes out the blocks buffered by enc.
+func (z *Writer) flush() error {
+	err := z.write(z.enc.out.Bytes())
+	z.enc.out.Reset()
+	return err
+}
+
+// write writes p to the underlying writer.
+func (z *Writer) write(p []byte) error {
+	n, err := z.w.Write(p)
+	z.written += int64(n)
+	return err
+}
+
+// writeHeader writes the stream header, unless it was written already.
+func (z *Writer) writeHeader() error {
+	if z.wroteHeader {
+		return nil
+	}
+	z.wroteHeader = true
+	return z.write(newHeader(z.opts).marshal())
+}
+
+// writeBlock encodes pending input, split into blocks where its statistics
+// change, and keeps the end of it as history for the next one.
+func (z *Writer) writeBlock() error {
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+	if z.opts.Concurrency > 0 {
+		return z.startJob()
+	}
+	var long []longMatch
+	if z.lm != nil {
+		long = z.lm.find(z.buf, z.start)
+		for i := range long {
+			long[i].pos -= z.mfBase
+		}
+	}
+	if err := z.enc.writeInput(z.mf, z.buf[z.mfBase:], z.start-z.mfBase, long); err != nil {
+		return err
+	}
+	if err := z.flush(); err != nil {
+		return err
+	}
+
+	// Sliding moves the whole buffer and match finder tables, so it is
+	// done only once at least a window worth of data can be dropped.
+	z.start = len(z.buf)
+	if window := int(z.opts.SearchSize); len(z.buf)-z.mfBase >= 2*window {
+		z.slideMatchFinder(len(z.buf) - window)
+	}
+	if window := int(z.opts.window()); len(z.buf) >= 2*window {
+		n := len(z.buf) - window
+		z.slideMatchFinder(n)
+		z.buf = z.buf[:copy(z.buf, z.buf[n:])]
+		if z.lm != nil {
+			z.lm.slide(n)
+		}
+		z.mfBase -= n
+		z.start = window
+	}
+	return nil
+}
+
+// slideMatchFinder makes the match finder drop input before base.
+func (z *Writer) slideMatchFinder(base int) {
+	if base > z.mfBase {
+		z.mf.slide(base - z.mfBase)
+		z.mfBase = base
+	}
+}
+
+// blockWriter codes input as blocks, which it buffers in out.
+type blockWriter struct {
+	// bw writes to out, so that incompressible blocks can be replaced
+	// with stored ones before they are written out.
+	bw   binaryWriter
+	out  bytes.Buffer
+	opts Options
+}
+
+func newBlockWriter(opts Options) *blockWriter {
+	b := &blockWriter{opts: opts}
+	b.bw = newBinaryWriter(&b.out)
+	return b
+}
+
+// writeInput codes input[start:], with input[:start] as history for
+// matches. mf is set to index input. long holds long-distance matches
+// found in input[start:].
+func (b *blockWriter) writeInput(mf matchFinder, input []byte, start int, long []longMatch) error {
+	// LZ coding.
+	mf.setInput(input)
+	values := bytesToValues(mf, input, start, b.opts, long)
+	if b.opts.LZ != ioutil.Discard {
+		for _, v := range values {
+			if _, err := fmt.Fprintf(b.opts.LZ, "%v", v); err != nil {
+				return err
+			}
+		}
+	}
+	// Huffman coding, with a code for every part of the input.
+	raw := input[start:]
+	for _, part := range splitValues(values, int(b.opts.MaxCodeLength)) {
+		n := valuesLen(part)
+		if err := b.writePart(part, raw[:n]); err != nil {
+			return err
+		}
+		raw = raw[n:]
+	}
+	return nil
+}
+
+// writePart writes values, which encode raw, as a block. It is coded with
+// the codes of the previous block if they are not worse than new ones, or
+// stored if that is smaller.
+func (b *blockWriter) writePart(values []Value, raw []byte) error {
+	litLen, dist := countFreqs(values)
+	limit := int(b.opts.MaxCodeLength)
+	litLenLengths, distLengths := huffmanCodeLengths(litLen, limit), huffmanCodeLengths(dist, limit)
+	bits, _ := codedBits(litLen, dist, litLenLengths, distLengths)
+	bits += tableBits(litLenLengths, distLengths)
+	// Codes in use are restored if the block ends up stored.
+	prev := append([]byte{}, b.bw.lengths...)
+	reuseBits, reuse := codedBits(litLen, dist, prev[:min(len(prev), litLenSize)], prev[min(len(prev), litLenSize):])
+	reuse = reuse && reuseBits <= bits
+	if !reuse {
+		b.bw.setCodeLengths(litLenLengths, distLengths)
+		if b.opts.Graphviz != ioutil.Discard {
+			root := newCodeTree(b.bw.litLen, litLen)
+			root.DumpGraphviz(b.opts.Graphviz)
+			root = newCodeTree(b.bw.dist, dist)
+			root.DumpGraphviz(b.opts.Graphviz)
+		}
+	}
+	// Write binary representation, or the input itself if it is smaller.
+	off := b.out.Len()
+	if err := b.bw.writeBlock(values, reuse); err != nil {
+		return err
+	}
+	if b.out.Len()-off > storedHeaderSize+len(raw) {
+		b.out.Truncate(off)
+		if !reuse && len(prev) > 0 {
+			b.bw.setCodeLengths(prev[:litLenSize], prev[litLenSize:])
+		} else if !reuse {
+			// No codes were written yet, so there are none to reuse.
+			b.bw.lengths = nil
+		}
+		if err := b.bw.writeStored(raw); err != nil {
+			return err
+		}
+	}
+	if b.opts.Checksum != ChecksumNone {
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], blockChecksum(raw))
+		b.out.Write(sum[:])
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package reductor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func Test_WriterReaderBlocks(t *testing.T) {
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 500)
+	var tests = []struct {
+		name      string
+		blockSize int
+		chunkSize int
+	}{
+		{name: "Single block", blockSize: blockSize, chunkSize: len(input)},
+		{name: "Block per write", blockSize: 1000, chunkSize: 1000},
+		{name: "Writes spanning blocks", blockSize: 1000, chunkSize: 777},
+		{name: "Tiny blocks", blockSize: 7, chunkSize: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var compressed bytes.Buffer
+			zw, err := NewWriter(&compressed, Options{SearchSize: 300})
+			if err != nil {
+				t.Fatal(err)
+			}
+			zw.blockSize = tt.blockSize
+			for p := input; len(p) > 0; {
+				k := min(len(p), tt.chunkSize)
+				if _, err := zw.Write(p[:k]); err != nil {
+					t.Fatalf("write: %v", err)
+				}
+				p = p[k:]
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+			zr, err := NewReader(&compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Read a byte at a time to exercise partial reads of a block.
+			got, err := ioutil.ReadAll(iotest.OneByteReader(zr))
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !bytes.Equal(got, input) {
+				t.Errorf("roundtrip mismatch (got %d bytes want %d bytes)", len(got), len(input))
+			}
+		})
+	}
+}
+
+func Test_WriterLargeWindow(t *testing.T) {
+	// Copies of a random chunk are only found with a window larger than
+	// 64 KiB, and are best coded with matches longer than 255 bytes.
+	chunk := make([]byte, 100<<10)
+	rand.New(rand.NewSource(1)).Read(chunk)
+	input := bytes.Repeat(chunk, 5)
+	for _, kind := range []MatchFinder{MatchFinderHashChain, MatchFinderBinaryTree} {
+		t.Run(kind.String(), func(t *testing.T) {
+			var compressed, lz bytes.Buffer
+			zw, err := NewWriter(&compressed, Options{MaxMatch: 65535, SearchSize: 128 << 10, MatchFinder: kind, LZ: &lz})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Small blocks make the window slide a few times.
+			zw.blockSize = 64 << 10
+			if _, err := zw.Write(input); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("<%d,65535>", len(chunk)); !strings.Contains(lz.String(), want) {
+				t.Errorf("no %s pointer in the LZ representation", want)
+			}
+			if compressed.Len() > len(chunk)+len(chunk)/10 {
+				t.Errorf("copies of the chunk were not found (got %d bytes)", compressed.Len())
+			}
+			var got bytes.Buffer
+			if err := Decompress(&got, &compressed); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), input) {
+				t.Errorf("roundtrip mismatch (got %d bytes want %d bytes)", got.Len(), len(input))
+			}
+		})
+	}
+}
+
+func Test_WriterWriteAfterClose(t *testing.T) {
+	zw, err := NewWriter(ioutil.Discard, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write([]byte("a")); err == nil {
+		t.Error("expected an error writing to a closed Writer")
+	}
+}
+
+func Test_ReaderTruncated(t *testing.T) {
+	var compressed bytes.Buffer
+	if err := Compress(&compressed, bytes.NewReader(bytes.Repeat([]byte("abcd"), 100)), Options{}); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := NewReader(bytes.NewReader(compressed.Bytes()[:compressed.Len()-2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, zr); err != io.ErrUnexpectedEOF {
+		t.Errorf("unexpected error (got %v want %v)", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func Test_ReaderBlockTooLarge(t *testing.T) {
+	// A literal repeated by matches of the longest length, which decode to
+	// more than a block of input.
+	values := []Value{NewValue(true, 'a', 1, 0)}
+	for size := 1; size <= blockSize; size += maxLength {
+		values = append(values, NewValue(false, 0, maxLength, 1))
+	}
+	var stream bytes.Buffer
+	stream.Write(newHeader(Options{Checksum: ChecksumNone}.withDefaults()).marshal())
+	bw := newBinaryWriter(&stream)
+	litLen, dist := countFreqs(values)
+	bw.setCodeLengths(huffmanCodeLengths(litLen, DefaultMaxCodeLength), huffmanCodeLengths(dist, DefaultMaxCodeLength))
+	if err := bw.writeBlock(values, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := bw.writeEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Decompress(ioutil.Discard, &stream); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("unexpected error (got %v want %v)", err, ErrCorrupt)
+	}
+}
+
+func Test_WriterStoredBlocks(t *testing.T) {
+	// Random blocks are stored, text blocks are not.
+	rng := rand.New(rand.NewSource(3))
+	random := make([]byte, 3*1000)
+	rng.Read(random)
+	text := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 100)[:4000]
+	var tests = []struct {
+		name  string
+		input []byte
+		// maxSize bounds the compressed size.
+		maxSize int
+	}{
+		// The header, a count and a CRC per block, the end marker and the trailer.
+		{name: "Random", input: random, maxSize: len(random) + 11 + 3*8 + 4 + 4},
+		// Text compresses to a fraction of its size.
+		{name: "Mixed", input: append(append(append([]byte{}, text...), random...), text...), maxSize: len(random) + 2*len(text)/10 + 11 + 11*8 + 4 + 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var compressed bytes.Buffer
+			zw, err := NewWriter(&compressed, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			zw.blockSize = 1000
+			if _, err := zw.Write(tt.input); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if compressed.Len() > tt.maxSize {
+				t.Errorf("input expanded too much (got %d bytes want at most %d)", compressed.Len(), tt.maxSize)
+			}
+			var got bytes.Buffer
+			if err := Decompress(&got, &compressed); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), tt.input) {
+				t.Errorf("roundtrip mismatch (got %d bytes want %d bytes)", got.Len(), len(tt.input))
+			}
+		})
+	}
+}
+
+func Test_ReaderBlockCountCorrupt(t *testing.T) {
+	h := newHeader(Options{Checksum: ChecksumNone}.withDefaults()).marshal()
+	var tests = []struct {
+		name  string
+		count uint32
+		data  []byte
+		want  error
+	}{
+		{name: "Empty stored", count: storedFlag, want: ErrCorrupt},
+		{name: "Too long", count: storedFlag | (blockSize + 1), want: ErrCorrupt},
+		{name: "Truncated", count: storedFlag | 10, data: []byte("abc"), want: io.ErrUnexpectedEOF},
+		{name: "Reuse without codes", count: reuseFlag | 10, want: ErrCorrupt},
+		{name: "Too many values", count: blockSize + 1, want: ErrCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var count [4]byte
+			binary.BigEndian.PutUint32(count[:], tt.count)
+			stream := append(append(append([]byte{}, h...), count[:]...), tt.data...)
+			if err := Decompress(ioutil.Discard, bytes.NewReader(stream)); !errors.Is(err, tt.want) {
+				t.Errorf("unexpected error (got %v want %v)", err, tt.want)
+			}
+		})
+	}
+}
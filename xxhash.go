@@ -0,0 +1,116 @@
+package reductor
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// XXH64 primes.
+const (
+	prime64_1 = 11400714785074694791
+	prime64_2 = 14029467366897019727
+	prime64_3 = 1609587929392839161
+	prime64_4 = 9650029242287828579
+	prime64_5 = 2870177450012600261
+)
+
+// xxh64 implements hash.Hash64 computing XXH64 with a zero seed.
+type xxh64 struct {
+	v     [4]uint64
+	total uint64
+	mem   [32]byte
+	n     int // Bytes buffered in mem.
+}
+
+func newXXH64() hash.Hash64 {
+	var d xxh64
+	d.Reset()
+	return &d
+}
+
+func (d *xxh64) Reset() {
+	p1 := uint64(prime64_1)
+	d.v = [4]uint64{p1 + prime64_2, prime64_2, 0, -p1}
+	d.total = 0
+	d.n = 0
+}
+
+func (d *xxh64) Size() int      { return 8 }
+func (d *xxh64) BlockSize() int { return 32 }
+
+func (d *xxh64) Write(p []byte) (int, error) {
+	n := len(p)
+	d.total += uint64(n)
+	if d.n+len(p) < 32 {
+		d.n += copy(d.mem[d.n:], p)
+		return n, nil
+	}
+	if d.n > 0 {
+		k := copy(d.mem[d.n:], p)
+		p = p[k:]
+		d.stripe(d.mem[:])
+		d.n = 0
+	}
+	for ; len(p) >= 32; p = p[32:] {
+		d.stripe(p)
+	}
+	d.n = copy(d.mem[:], p)
+	return n, nil
+}
+
+// stripe consumes 32 bytes of input.
+func (d *xxh64) stripe(p []byte) {
+	for i := range d.v {
+		d.v[i] = xxh64Round(d.v[i], binary.LittleEndian.Uint64(p[8*i:]))
+	}
+}
+
+func (d *xxh64) Sum64() uint64 {
+	var h uint64
+	if d.total >= 32 {
+		v := d.v
+		h = bits.RotateLeft64(v[0], 1) + bits.RotateLeft64(v[1], 7) +
+			bits.RotateLeft64(v[2], 12) + bits.RotateLeft64(v[3], 18)
+		for i := range v {
+			h = (h^xxh64Round(0, v[i]))*prime64_1 + prime64_4
+		}
+	} else {
+		h = prime64_5
+	}
+	h += d.total
+
+	p := d.mem[:d.n]
+	for ; len(p) >= 8; p = p[8:] {
+		h ^= xxh64Round(0, binary.LittleEndian.Uint64(p))
+		h = bits.RotateLeft64(h, 27)*prime64_1 + prime64_4
+	}
+	if len(p) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(p)) * prime64_1
+		h = bits.RotateLeft64(h, 23)*prime64_2 + prime64_3
+		p = p[4:]
+	}
+	for _, b := range p {
+		h ^= uint64(b) * prime64_5
+		h = bits.RotateLeft64(h, 11) * prime64_1
+	}
+
+	h ^= h >> 33
+	h *= prime64_2
+	h ^= h >> 29
+	h *= prime64_3
+	h ^= h >> 32
+	return h
+}
+
+func (d *xxh64) Sum(b []byte) []byte {
+	var s [8]byte
+	binary.BigEndian.PutUint64(s[:], d.Sum64())
+	return append(b, s[:]...)
+}
+
+func xxh64Round(acc, input uint64) uint64 {
+	acc += input * prime64_2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime64_1
+}